@@ -1,9 +1,38 @@
 package typegen
 
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
 type Assignments []*Assignment
 
 type Assignment struct {
-	LHS string
-	Op  string
-	RHS string
+	LHS ast.Expr
+	Op  token.Token
+	RHS ast.Expr
+}
+
+// Stmt returns the *ast.AssignStmt for the Assignment, e.g. `var1.prop = &var2`.
+func (a *Assignment) Stmt() *ast.AssignStmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{a.LHS},
+		Tok: a.Op,
+		Rhs: []ast.Expr{a.RHS},
+	}
+}
+
+// String renders the Assignments as Go source code, one per line.
+func (as Assignments) String() string {
+	sb := strings.Builder{}
+	for _, a := range as {
+		sb.WriteString(exprString(a.LHS))
+		sb.WriteByte(' ')
+		sb.WriteString(a.Op.String())
+		sb.WriteByte(' ')
+		sb.WriteString(exprString(a.RHS))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
 }
@@ -0,0 +1,145 @@
+package typegen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// typeExpr returns an ast.Expr for a type name such as `[]int`, `map[string]any`
+// or `*foo.Bar`. If the name cannot be parsed as an expression it is returned as
+// an *ast.Ident which `go/printer` will output verbatim.
+func typeExpr(name string) (expr ast.Expr) {
+	expr, err := parser.ParseExpr(name)
+	if err != nil {
+		expr = ast.NewIdent(name)
+		goto end
+	}
+	rawTags(expr)
+end:
+	return expr
+}
+
+// rawTags rewrites the struct tags of the anonymous struct types within expr as
+// raw strings, since `reflect.Type.String()` quotes them, e.g. `json:"id"`
+// rather than "json:\"id\"".
+func rawTags(expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		field, ok := n.(*ast.Field)
+		if !ok || field.Tag == nil {
+			return true
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err == nil {
+			field.Tag = tagLit(tag)
+		}
+		return true
+	})
+}
+
+// codeExpr returns an ast.Expr for a fragment of Go code such as the return
+// value of a Substitutions func. If the code cannot be parsed as an expression
+// it is returned as an *ast.Ident which `go/printer` will output verbatim.
+func codeExpr(code string) ast.Expr {
+	return typeExpr(code)
+}
+
+// numberLit returns a *ast.BasicLit for a number formatted as a string. Negative
+// numbers are returned as an *ast.UnaryExpr so that the AST matches what
+// `go/parser` would produce for the same source.
+func numberLit(kind token.Token, s string) (expr ast.Expr) {
+	if strings.HasPrefix(s, "-") {
+		expr = &ast.UnaryExpr{
+			Op: token.SUB,
+			X:  &ast.BasicLit{Kind: kind, Value: s[1:]},
+		}
+		goto end
+	}
+	expr = &ast.BasicLit{Kind: kind, Value: s}
+end:
+	return expr
+}
+
+// intLit returns an *ast.BasicLit for an int.
+func intLit(i int) ast.Expr {
+	return numberLit(token.INT, strconv.Itoa(i))
+}
+
+// stringLit returns an *ast.BasicLit for a double-quoted string.
+func stringLit(s string) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(s)}
+}
+
+// isNilIdent returns true if expr is the identifier `nil`.
+func isNilIdent(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "nil"
+}
+
+// callExpr returns an *ast.CallExpr that calls fun with args. It is also used for
+// type conversions, e.g. `int8(10)`.
+func callExpr(fun ast.Expr, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{Fun: fun, Args: args}
+}
+
+// addressOf returns an *ast.UnaryExpr taking the address of x, e.g. `&var1`.
+func addressOf(x ast.Expr) *ast.UnaryExpr {
+	return &ast.UnaryExpr{Op: token.AND, X: x}
+}
+
+// compositeLit returns an *ast.CompositeLit for the type name and elements passed.
+func compositeLit(typ string, elts []ast.Expr) *ast.CompositeLit {
+	return &ast.CompositeLit{Type: typeExpr(typ), Elts: elts}
+}
+
+// printerConfig returns the `go/printer` configuration that will indent using
+// indent, which should contain either spaces or a tab.
+func printerConfig(indent string) (cfg *printer.Config) {
+	if indent == "" || strings.Contains(indent, "\t") {
+		cfg = &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+		goto end
+	}
+	cfg = &printer.Config{Mode: printer.UseSpaces, Tabwidth: len(indent)}
+end:
+	return cfg
+}
+
+// childNodes returns the direct children of an AST node in the order visited
+// by `ast.Inspect()`.
+func childNodes(root ast.Node) (nodes []ast.Node) {
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n == root {
+			return true
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		return false
+	})
+	return nodes
+}
+
+// tagLit returns a *ast.BasicLit for a struct tag, as a raw string literal as it
+// would typically be written unless the tag contains a backtick.
+func tagLit(tag string) (lit *ast.BasicLit) {
+	if strings.Contains(tag, "`") {
+		lit = stringLit(tag)
+		goto end
+	}
+	lit = &ast.BasicLit{Kind: token.STRING, Value: "`" + tag + "`"}
+end:
+	return lit
+}
+
+// elideType removes the type from expr if it is a composite literal, for use as
+// an element, map key or map value of a composite literal whose element type
+// is the same, e.g. `[]Point{{X: 1}}` instead of `[]Point{Point{X: 1}}`.
+func elideType(expr ast.Expr) ast.Expr {
+	if cl, ok := expr.(*ast.CompositeLit); ok {
+		cl.Type = nil
+	}
+	return expr
+}
@@ -0,0 +1,174 @@
+package typegen
+
+import (
+	"go/ast"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// sliceRef is a slice Node and the reflect.Value it was marshaled from, which
+// has the address of the slice's first element that
+// `NodeMarshaler.shareBackingArrays()` needs to find slices that overlap.
+type sliceRef struct {
+	node *Node
+	rv   reflect.Value
+}
+
+// start returns the address of the first element of the slice.
+func (r sliceRef) start() uintptr {
+	return r.rv.Pointer()
+}
+
+// end returns the address just past the last element within the capacity of
+// the slice.
+func (r sliceRef) end() uintptr {
+	return r.start() + uintptr(r.rv.Cap())*r.rv.Type().Elem().Size()
+}
+
+// addSlice records a non-nil slice Node, including a BytesNode of a `[]byte`,
+// for `NodeMarshaler.shareBackingArrays()`.
+func (m *NodeMarshaler) addSlice(rv *reflect.Value, node *Node) {
+	if rv.Kind() != reflect.Slice || rv.IsNil() || rv.Cap() == 0 {
+		goto end
+	}
+	if rv.Type().Elem().Size() == 0 {
+		// Elements of zero size all have the same address.
+		goto end
+	}
+	m.slices = append(m.slices, sliceRef{node: node, rv: *rv})
+end:
+}
+
+// findSlice returns the slice Node previously marshaled for the same slice as
+// rv, i.e. with the same first element, length and capacity, and true if found.
+// Slices that are merely equal are not the same slice since each can be
+// modified without affecting the other.
+func (m *NodeMarshaler) findSlice(rv *reflect.Value) (node *Node, found bool) {
+	for _, r := range m.slices {
+		if r.rv.Type() != rv.Type() {
+			continue
+		}
+		if r.start() != rv.Pointer() || r.rv.Len() != rv.Len() || r.rv.Cap() != rv.Cap() {
+			continue
+		}
+		node = r.node
+		found = true
+		break
+	}
+	return node, found
+}
+
+// shareBackingArrays finds the slices whose elements overlap, e.g. `buf[:4]` and
+// `buf[2:8]`, and thus share a backing array, and marshals the part of each
+// array they span as a Node of its own. Each slice is given its array as its
+// `Node.Backing` so that `CodeBuilder` can generate the slices by re-slicing the
+// array, e.g. `var1[2:8:10]`, which preserves the aliasing. The arrays are moved
+// to the front of the Nodes so that their variables are declared before any
+// variable that references them.
+func (m *NodeMarshaler) shareBackingArrays() {
+	var types []reflect.Type
+	var backings Nodes
+
+	byType := make(map[reflect.Type][]sliceRef)
+	for _, r := range m.slices {
+		elem := r.rv.Type().Elem()
+		if _, found := byType[elem]; !found {
+			types = append(types, elem)
+		}
+		byType[elem] = append(byType[elem], r)
+	}
+	for _, elem := range types {
+		refs := byType[elem]
+		sort.SliceStable(refs, func(i, j int) bool {
+			return refs[i].start() < refs[j].start()
+		})
+		for i := 0; i < len(refs); {
+			j := i + 1
+			end := refs[i].end()
+			for ; j < len(refs) && refs[j].start() < end; j++ {
+				end = max(end, refs[j].end())
+			}
+			if j-i > 1 {
+				backings = append(backings, m.shareBackingArray(refs[i:j], end))
+			}
+			i = j
+		}
+	}
+	if len(backings) == 0 {
+		goto end
+	}
+	m.nodes = append(append(Nodes{nil}, backings...), slices.DeleteFunc(m.nodes[1:], func(n *Node) bool {
+		return slices.Contains(backings, n)
+	})...)
+end:
+}
+
+// shareBackingArray marshals the array shared by refs, which are sorted by the
+// address of their first element, up to end, sets it as the Backing of each and
+// returns it.
+func (m *NodeMarshaler) shareBackingArray(refs []sliceRef, end uintptr) (backing *Node) {
+	elem := refs[0].rv.Type().Elem()
+	base := refs[0].start()
+	rt := reflect.ArrayOf(int((end-base)/elem.Size()), elem)
+	array := reflect.NewAt(rt, refs[0].rv.UnsafePointer()).Elem()
+	backing = m.marshalValue(&array, nil)
+	// A byte array is a BytesNode which marshalValue() does not register.
+	m.registerNode(&array, backing)
+	for _, r := range refs {
+		r.node.Backing = backing
+		r.node.Offset = int((r.start() - base) / elem.Size())
+	}
+	return backing
+}
+
+// sliceLen returns the length of the slice Node n, which for a BytesNode is the
+// number of bytes rather than the number of child Nodes.
+func sliceLen(n *Node) (length int) {
+	if n.Type == BytesNode {
+		length = len(nodeBytes(n.Value))
+		goto end
+	}
+	length = len(n.nodes)
+end:
+	return length
+}
+
+// backingSliceExpr generates a slice that shares its backing array with other
+// slices as a re-slicing of the variable declared for the array, e.g.
+// `var1[2:8:10]`, converted to the slice's type if it is named.
+func (b *CodeBuilder) backingSliceExpr(n *Node) (expr ast.Expr) {
+	low := n.Offset
+	expr = &ast.SliceExpr{
+		X:      ast.NewIdent(b.nodeVarname(n.Backing)),
+		Low:    intLit(low),
+		High:   intLit(low + sliceLen(n)),
+		Max:    intLit(low + n.Cap),
+		Slice3: true,
+	}
+	if strings.HasPrefix(n.Typename, "[]") {
+		goto end
+	}
+	expr = callExpr(typeExpr(b.nodeTypename(n)), expr)
+end:
+	return expr
+}
+
+// findBackings fills `CodeBuilder.backings` with the array Nodes referenced by
+// `Node.Backing` from any Node reachable from the Nodes.
+func (b *CodeBuilder) findBackings() {
+	seen := make(map[int]*Node)
+	for _, n := range b.nodes {
+		if n == nil {
+			continue
+		}
+		collectNodes(n, seen)
+	}
+	for _, n := range seen {
+		if n.Backing == nil {
+			continue
+		}
+		b.backings[n.Backing] = struct{}{}
+	}
+}
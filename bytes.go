@@ -0,0 +1,92 @@
+package typegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BytesFormat determines how `CodeBuilder` generates the values of `[]byte`
+// and byte arrays that are not printable UTF-8 text. Printable text is always
+// generated as a string conversion, e.g. `[]byte("Hello")`.
+type BytesFormat int
+
+const (
+	// EscapedBytes generates a conversion of a string literal with escapes, e.g.
+	// `[]byte("\x00\x01")`.
+	EscapedBytes BytesFormat = iota
+
+	// HexBytes generates a composite literal of hex bytes, e.g.
+	// `[]byte{0x00, 0x01}`.
+	HexBytes
+)
+
+var byteType = reflect.TypeOf(byte(0))
+
+// isBytes returns true if rt is a slice or array of bytes, including named types
+// such as `json.RawMessage`.
+func isBytes(rt reflect.Type) (is bool) {
+	switch rt.Kind() {
+	case reflect.Slice, reflect.Array:
+		is = rt.Elem() == byteType
+	}
+	return is
+}
+
+// nodeBytes returns the bytes of the value of a BytesNode, which is either a
+// slice or an array of bytes.
+func nodeBytes(v any) (data []byte) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		data = rv.Bytes()
+	case reflect.Array:
+		data = make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(data), rv)
+	}
+	return data
+}
+
+// isPrintableText returns true if data is valid UTF-8 containing only printable
+// characters, newlines and tabs.
+func isPrintableText(data []byte) (printable bool) {
+	if !utf8.Valid(data) {
+		goto end
+	}
+	for _, r := range string(data) {
+		if unicode.IsPrint(r) {
+			continue
+		}
+		if r == '\n' || r == '\r' || r == '\t' {
+			continue
+		}
+		goto end
+	}
+	printable = true
+end:
+	return printable
+}
+
+// hexLits returns a hex *ast.BasicLit for each byte of data, e.g. `0x0a`.
+func hexLits(data []byte) []ast.Expr {
+	elts := make([]ast.Expr, len(data))
+	for i, c := range data {
+		elts[i] = &ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("0x%02x", c)}
+	}
+	return elts
+}
+
+// base64DecodeExpr returns an expression that decodes the base64 encoding of
+// data at runtime, for byte values too large to be readable as literals.
+func base64DecodeExpr(encoded string) ast.Expr {
+	return codeExpr(fmt.Sprintf(
+		"func() []byte { b, _ := base64.StdEncoding.DecodeString(%q); return b }()",
+		encoded,
+	))
+}
+
+var bytesTypenameRE = regexp.MustCompile(`^(\[\d*])uint8$`)
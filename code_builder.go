@@ -1,10 +1,17 @@
 package typegen
 
 import (
+	"encoding/base64"
 	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mikeschinkel/go-diffator"
 	. "github.com/mikeschinkel/go-lib"
@@ -12,15 +19,73 @@ import (
 
 type CodeBuilder struct {
 
-	// Builder caches the output during generation, and is embedded so CodeBuilder
-	// objects can call its methods directly.
-	strings.Builder
-
 	// Indent typically contains two spaces which are used to output code for
-	// indentation, but can be replaced with (a) tab(s) or a different number of
-	// spaces when this package is used. NOTE: The tests assume two spaces.
+	// indentation when `CodeBuilder.Build()` renders the AST, but can be replaced
+	// with a tab or a different number of spaces when this package is used. NOTE:
+	// The tests assume two spaces.
 	Indent string
 
+	// Types, when set, resolves the types of Nodes from the static type information
+	// of the packages that declare them so that the type names generated are exact,
+	// including those of generic types. The import paths they need are added to the
+	// *ast.File returned by `CodeBuilder.BuildAST()`. Types that cannot be resolved,
+	// e.g. those declared within a func, fall back to the names derived from reflect.
+	Types *TypeResolver
+
+	// ImportAliases maps import paths, or package names, to the name to reference
+	// the package by in generated code. It is consulted for each package-qualified
+	// type name derived from reflect, including those within the type arguments of
+	// generic types, which reflect qualifies by import path. Packages not found are
+	// referenced by their likely package name, e.g. `url` for `net/url`.
+	ImportAliases map[string]string
+
+	// StringFormat determines whether multi-line strings are generated as raw
+	// string literals. It defaults to QuotedStrings.
+	StringFormat StringFormat
+
+	// MaxStringLength, when greater than zero, is the length above which strings
+	// are split at line boundaries into a concatenation of literals, e.g.
+	// `"line 1\n" + "line 2\n"`, with each literal on its own line.
+	MaxStringLength int
+
+	// Runes, when true, generates all int32 values as rune literals, e.g. `'A'`,
+	// and slices of int32 as conversions of strings, e.g. `[]rune("héllo")`.
+	// `rune` is an alias for `int32` which reflect cannot distinguish, so without
+	// Runes only values declared as runes are detected, and only when Types is set.
+	Runes bool
+
+	// BytesFormat determines how `[]byte` and byte array values that are not
+	// printable text are generated. It defaults to EscapedBytes.
+	BytesFormat BytesFormat
+
+	// Base64Threshold, when greater than zero, is the length above which `[]byte`
+	// and byte array values that are not printable text are generated as base64
+	// that is decoded at runtime, which is far more compact than either
+	// BytesFormat for large blobs.
+	Base64Threshold int
+
+	// Funcs maps the names `runtime.FuncForPC()` reports for func values to the
+	// code to generate for them, for closures and methods which would otherwise be
+	// generated as stubs that panic. See FuncRegistry.
+	Funcs FuncRegistry
+
+	// Capacity, when true, generates slices whose capacity exceeds their length
+	// with `make()` so that the capacity is preserved, e.g.
+	// `append(make([]int, 0, 8), []int{1, 2}...)`. Capacity matters to code that
+	// appends to a slice since `append()` only allocates a new array when a slice
+	// is full.
+	Capacity bool
+
+	// Formatters maps the names of unit types, as reflect names them, e.g.
+	// `typegen_test.ByteSize`, to the UnitFormatter that generates their values as
+	// a count of one of their units, e.g. `4 * KiB`. It is consulted before
+	// StdFormatters, which formats `time.Duration` values.
+	Formatters map[string]UnitFormatter
+
+	// UnsafePolicy determines how `unsafe.Pointer` and `uintptr` values are
+	// generated. It defaults to ZeroUnsafe.
+	UnsafePolicy UnsafePolicy
+
 	// omitPkg is the package name to be stripped from all types during code
 	// generation. Since Go does not allow using the name of the current package as a
 	// prefix, omitPkg allows code to be generated that does not include the current
@@ -35,7 +100,7 @@ type CodeBuilder struct {
 
 	// indexMap is a Node lookup nap keyed by reflect.Value with index into .nodes
 	// for it value. Used to find a node to nullify in .nodes if it does not need to
-	// be generated.  See .scalarChildWritten to see if used.
+	// be generated because `CodeBuilder.scalarChildExpr()` generated it in place.
 	indexMap IndexMap
 
 	// assignments is a slice of the assignments that need to be generated after for
@@ -43,26 +108,73 @@ type CodeBuilder struct {
 	// generated. These are registered in `CodeBuilder.registerAssignment()` from
 	// within `CodeBuilder.refNode()` which is called from `CodeBuilder.<Item>Node()`
 	// where `<Item>` are Go containers, and then they are generated in
-	// `NodeMarshaler.Build()` which calls `CodeBuilder.writeAssigment()`.
+	// `CodeBuilder.BuildAST()` which calls `Assignment.Stmt()`.
 	assignments Assignments
 
+	// entryAssignments contains the assignments of map entries whose keys or
+	// values reference variables, e.g. `var1[&var2] = 10` or `var1["a"] = var3`,
+	// and of structs and arrays that reference variables to interfaces. They are
+	// generated after assignments so that the variables referenced are complete
+	// before they are copied into maps and interfaces.
+	entryAssignments Assignments
+
 	// varnameCtr keeps track of the next variable name suffix, e.g. `var`, `var2`,
 	// `var3`, ... `varN``.  This is used in `CodeBuilder.nodeVarname()`
 	varnameCtr int
 
-	// prefixLen is set in `NodeMarshaler.Build()` to specify have make bytes it has
-	// written to the embedded `strings.Builder` of this `CodeBuilder` so that
-	// `CodeBuilder.refNode()` can tell if the CodeBuilder has written any data or not.
-	// If it has not then it should call `CodeBuilder.WriteCode()` on the current node
-	// since it will be the first node, otherwise it would output `nil` for use in a
-	// container property, are as a variable to be references if the code was already
-	// generated.
-	prefixLen int
+	// varNode is set in `CodeBuilder.BuildAST()` to the Node whose variable
+	// assignment is currently being generated so that `CodeBuilder.refNode()` can
+	// tell if the node it was passed is that first node. If it is then it should
+	// call `CodeBuilder.NodeExpr()` on it, otherwise it would output `nil` for use
+	// in a container property, or a variable to be referenced if the code was
+	// already generated.
+	varNode *Node
+
+	// exprNodes maps each ast.Expr returned by `CodeBuilder.NodeExpr()` to the Node
+	// it was generated for so that diagnostics found by `CodeBuilder.Validate()` can
+	// be mapped back to the Node that generated the offending code.
+	exprNodes map[ast.Node]*Node
+
+	// imports contains the import paths needed by the code generated, e.g.
+	// `encoding/base64`, which are added to the *ast.File returned by
+	// `CodeBuilder.BuildAST()`.
+	imports map[string]struct{}
+
+	// backings contains the array Nodes that slices sharing a backing array
+	// re-slice, see `Node.Backing`. Their variables are declared first, so
+	// `CodeBuilder.BuildAST()` returns the variable declared after them.
+	backings map[*Node]struct{}
+
+	// inlined contains the struct Nodes generated in place within the code
+	// generated for another Node so that `CodeBuilder.BuildAST()` does not also
+	// generate a variable for them.
+	inlined map[*Node]struct{}
+
+	// fset positions the literals of strings split into concatenations on their
+	// own lines, which is the only way to have `go/printer` break lines within an
+	// expression.
+	fset *token.FileSet
+
+	// file caches the *ast.File returned by `CodeBuilder.BuildAST()` since building
+	// it consumes the state of the CodeBuilder.
+	file *ast.File
 
 	nodes     Nodes
 	funcName  string
 	Index     int
 	nodeStack Stack[int]
+
+	// slots contains the Field and Element Nodes, and the keys of map entries,
+	// whose values are being generated. A value reachable from more than one slot,
+	// e.g. a pointer held by both a map and a struct field, has only one Parent so
+	// `CodeBuilder.registerAssignment()` uses the slot on top to determine where
+	// the value is assigned.
+	slots Stack[*Node]
+
+	// expanded contains the container Nodes whose values are being generated, so
+	// that `CodeBuilder.refNode()` can detect a container that contains itself,
+	// e.g. a map with a value that is the map.
+	expanded map[*Node]struct{}
 }
 
 // NewCodeBuilder instantiates a new *CodeBuilder object with one param; the package
@@ -72,12 +184,19 @@ type CodeBuilder struct {
 func NewCodeBuilder(funcName, omitPkg string, nodes Nodes) *CodeBuilder {
 	return &CodeBuilder{
 		Indent:      "  ",
+		fset:        token.NewFileSet(),
 		omitPkg:     omitPkg,
 		funcName:    funcName,
 		nodes:       nodes,
 		nodeStack:   Stack[int]{},
+		slots:       Stack[*Node]{},
+		expanded:    make(map[*Node]struct{}),
 		genMap:      make(GenMap),
 		indexMap:    make(IndexMap),
+		exprNodes:   make(map[ast.Node]*Node),
+		inlined:     make(map[*Node]struct{}),
+		backings:    make(map[*Node]struct{}),
+		imports:     make(map[string]struct{}),
 		assignments: make(Assignments, 0),
 	}
 }
@@ -95,6 +214,10 @@ func (b *CodeBuilder) matchesPrior(n *Node, index int) (matches bool) {
 	if len(n.nodes) == 0 {
 		goto end
 	}
+	if b.nodes[index-1] == nil {
+		// The prior Node was a scalar nilled by `CodeBuilder.scalarChildExpr()`.
+		goto end
+	}
 	matches = diffator.Equivalent(
 		n.nodes[0].Value,
 		b.nodes[index-1].Value,
@@ -111,12 +234,13 @@ func (b *CodeBuilder) selectNode(index int) (n *Node, _ int, nt NodeType) {
 	// Get the current Node to decide if we use it or skip it
 	n = b.nodes[index]
 	if n == nil {
-		// b.scalarChildWritten() nils scalar nodes it does not need to output
+		// b.scalarChildExpr() nils scalar nodes it generated in place, so they need no
+		// variable of their own
 		goto end
 	}
-	if !OneOf(n.Type, InterfaceNode, PointerNode) {
+	if !OneOf(n.Type, InterfaceNode, PointerNode) || n.Nil {
 		// Anything besides a Pointer or Interface does not need to be skipped, unless it
-		// was nilled in `scalarChildWritten(), and we handled that already just before
+		// was nilled in `scalarChildExpr()`, and we handled that already just before
 		// this if statement.
 		goto end
 	}
@@ -129,10 +253,11 @@ func (b *CodeBuilder) selectNode(index int) (n *Node, _ int, nt NodeType) {
 	// We are going to skip it, so get the NodeType to return so .returnVarAndType()
 	// can know how if it needs to use pointer syntax.
 	nt = n.Type
-	if index >= b.NodeCount() {
-		// We are at the last Node in .nodes, but it is (unexpectedly?) a pointer or
-		// interface so we need to deference so we do not generate a pointer, since
-		// pointers are handled when generated os assignments.
+	if index >= b.NodeCount() || b.nodes[index+1] != n.nodes[0] {
+		// We are at the last Node in .nodes, or the pointer points to a scalar which
+		// has no Node of its own in .nodes, so we need to deference so we do not
+		// generate a pointer, since pointers are handled when generated os
+		// assignments.
 		n = n.nodes[0]
 		goto end
 	}
@@ -153,22 +278,59 @@ func (b *CodeBuilder) String() string {
 	return b.Build()
 }
 
+// Build renders the *ast.FuncDecl generated by `CodeBuilder.BuildAST()` as Go
+// source code using `go/printer`, indenting with `CodeBuilder.Indent`.
 func (b *CodeBuilder) Build() string {
-	var returnVar, returnType string
+	sb := strings.Builder{}
+	err := printerConfig(b.Indent).Fprint(&sb, b.fset, b.FuncDecl())
+	if err != nil {
+		Panicf("Unable to render generated code: %s", err)
+	}
+	return sb.String()
+}
+
+// FileSet returns the token.FileSet to pass to `go/printer` when printing the
+// AST returned by `CodeBuilder.BuildAST()` so that strings split into
+// concatenations per `CodeBuilder.MaxStringLength` are printed one literal per
+// line. Other positions in the AST are not set.
+func (b *CodeBuilder) FileSet() *token.FileSet {
+	return b.fset
+}
+
+// FuncDecl returns the *ast.FuncDecl of the func generated by
+// `CodeBuilder.BuildAST()`.
+func (b *CodeBuilder) FuncDecl() *ast.FuncDecl {
+	decls := b.BuildAST().Decls
+	return decls[len(decls)-1].(*ast.FuncDecl)
+}
+
+// BuildAST generates an *ast.File in the package named by omitPkg containing a
+// func named funcName that returns the value represented by the Nodes passed to
+// `NewCodeBuilder()`. Callers can rewrite, inject into or print the AST with
+// `go/printer`; `CodeBuilder.Build()` is simply a rendering of it.
+func (b *CodeBuilder) BuildAST() *ast.File {
+	var returnVar, returnType ast.Expr
+	var stmts []ast.Stmt
 	var n *Node
 	var nt NodeType
+	var nodeCnt int
+
+	if b.file != nil {
+		goto end
+	}
 
 	// Fill b.indexMap with reflect.Values from .nodes and their indexes into .nodes
-	// for quick lookup and nullification in .scalarChildWritten().
+	// for quick lookup and nullification in .scalarChildExpr().
 	for i := 1; i < len(b.nodes); i++ {
 		n = b.nodes[i]
 		b.indexMap[reflect.ValueOf(n.Value)] = i
 	}
+	b.findBackings()
 
-	nodeCnt := b.NodeCount()
+	nodeCnt = b.NodeCount()
 	for i := 1; i <= nodeCnt; i++ {
 		n, i, nt = b.selectNode(i)
-		// If nullified in .scalarChildWritten() because scalar already written then no
+		// If nullified in .scalarChildExpr() because scalar already written then no
 		// need to output.
 		if n == nil {
 			continue
@@ -178,66 +340,198 @@ func (b *CodeBuilder) Build() string {
 			// n is pointed at by prior, so we've already output it
 			continue
 		}
-		if returnVar == "" {
+		if _, found := b.inlined[n]; found {
+			continue
+		}
+		if _, found := b.backings[n]; !found && returnVar == nil {
 			returnVar, returnType = b.returnVarAndType(n, nt)
 		}
-		b.WriteString(fmt.Sprintf("%s%s := ", b.Indent, b.nodeVarname(n)))
-		b.prefixLen = b.Builder.Len()
-		b.WriteCode(n)
-		b.WriteByte('\n')
+		varname := ast.NewIdent(b.nodeVarname(n))
+		b.varNode = n
+		stmts = append(stmts, &ast.AssignStmt{
+			Lhs: []ast.Expr{varname},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{b.NodeExpr(n)},
+		})
 
 		// Record that this var has been generated
 		b.genMap[reflect.ValueOf(n.Value)] = n
 
 	}
 	for _, a := range b.assignments {
-		b.writeAssignment(a)
-	}
-	b.WriteString(fmt.Sprintf("%sreturn %s\n", b.Indent, returnVar))
-	b.WriteByte('}')
-	return fmt.Sprintf("func %s() %s {\n%s",
-		b.funcName,
-		returnType,
-		b.Builder.String(),
-	)
+		stmts = append(stmts, a.Stmt())
+	}
+	for _, a := range b.entryAssignments {
+		stmts = append(stmts, a.Stmt())
+	}
+	stmts = append(stmts, &ast.ReturnStmt{Results: []ast.Expr{returnVar}})
+	b.file = &ast.File{Name: ast.NewIdent(b.omitPkg)}
+	b.addImports(b.file, b.importSpecs())
+	b.file.Decls = append(b.file.Decls, &ast.FuncDecl{
+		Name: ast.NewIdent(b.funcName),
+		Type: &ast.FuncType{
+			Params:  &ast.FieldList{},
+			Results: &ast.FieldList{List: []*ast.Field{{Type: returnType}}},
+		},
+		Body: &ast.BlockStmt{List: stmts},
+	})
+end:
+	return b.file
+}
+
+// requireImport records that the code generated needs the package at path.
+func (b *CodeBuilder) requireImport(path string) {
+	b.imports[path] = struct{}{}
+}
+
+// importSpecs returns the import specs for the packages referenced by the types
+// resolved by `CodeBuilder.Types`, if set, and those recorded by
+// `CodeBuilder.requireImport()`, sorted by path.
+func (b *CodeBuilder) importSpecs() (specs []*ast.ImportSpec) {
+	var paths []string
+
+	seen := make(map[string]struct{})
+	if b.Types != nil {
+		for _, spec := range b.Types.ImportSpecs() {
+			specs = append(specs, spec)
+			seen[spec.Path.Value] = struct{}{}
+		}
+	}
+	for path := range b.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		lit := stringLit(path)
+		if _, found := seen[lit.Value]; found {
+			continue
+		}
+		spec := &ast.ImportSpec{Path: lit}
+		if name, found := b.ImportAliases[path]; found && name != guessPackageName(path) {
+			spec.Name = ast.NewIdent(name)
+		}
+		specs = append(specs, spec)
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		return specs[i].Path.Value < specs[j].Path.Value
+	})
+	return specs
+}
+
+// addImports adds an import declaration for specs to file, if there are any.
+func (b *CodeBuilder) addImports(file *ast.File, specs []*ast.ImportSpec) {
+	var decl *ast.GenDecl
+
+	if len(specs) == 0 {
+		goto end
+	}
+	decl = &ast.GenDecl{Tok: token.IMPORT, Lparen: 1}
+	for _, spec := range specs {
+		decl.Specs = append(decl.Specs, spec)
+	}
+	file.Imports = append(file.Imports, specs...)
+	file.Decls = append(file.Decls, decl)
+end:
+}
+
+// qualifiedTypename rewrites a type name derived from reflect for use in
+// generated code, replacing `interface {}` with `any` and qualifying each type
+// with the name returned by `CodeBuilder.packageName()`.
+func (b *CodeBuilder) qualifiedTypename(name string) string {
+	return rewriteTypename(replaceInterfaceWithAny(name), b.packageName)
+}
+
+// packageName returns the name to reference the package pkg by, which is an
+// import path or a package name, or an empty string for omitPkg.
+func (b *CodeBuilder) packageName(pkg string) (name string) {
+	name, found := b.ImportAliases[pkg]
+	if !found {
+		name = guessPackageName(pkg)
+	}
+	if name == b.omitPkg {
+		name = ""
+	}
+	return name
+}
+
+// nodeTypename returns the name of the type of a container Node for use in a
+// composite literal. It is derived from Typename rather than Name since
+// `NodeMarshaler` names the values of elements after their index, e.g. `Value 0`.
+func (b *CodeBuilder) nodeTypename(n *Node) string {
+	return b.resolvedTypename(n, b.qualifiedTypename(n.Typename))
+}
+
+// resolvedTypename returns the name of the type of n resolved by
+// `CodeBuilder.Types`, or name if Types is not set or could not resolve it.
+func (b *CodeBuilder) resolvedTypename(n *Node, name string) (s string) {
+	var rt reflect.Type
+	var err error
+
+	s = name
+	if b.Types == nil {
+		goto end
+	}
+	rt = n.ReflectType()
+	if rt == nil {
+		goto end
+	}
+	name, err = b.Types.TypeString(rt)
+	if err != nil {
+		goto end
+	}
+	s = name
+end:
+	return s
 }
 
-// WriteCode accepts a *Node and writes code to the embedded strings.Builder that
-// will create that node. Note that it should only output one level and expect
-// properties that are containers — array, slice, struct, ptr, map, etc. — to be
-// generated separately. This function will add an `*Assignment` for each of
-// those properties.
-func (b *CodeBuilder) WriteCode(n *Node) {
-	var rv reflect.Value
+// NodeExpr accepts a *Node and returns an ast.Expr that will create that node.
+// Note that it should only generate one level and expect properties that are
+// containers — array, slice, struct, ptr, map, etc. — to be generated
+// separately. This function will add an `*Assignment` for each of those
+// properties.
+func (b *CodeBuilder) NodeExpr(n *Node) (expr ast.Expr) {
 	var unhandled bool
 
-	n.Name = maybeStripPackage(n.Name, b.omitPkg)
-	n.Name = replaceInterfaceWithAny(n.Name)
+	n.Name = b.qualifiedTypename(n.Name)
 	resetDebugString(n)
 
+	expr = b.constantExpr(n)
+	if expr != nil {
+		goto end
+	}
+	expr = b.unitExpr(n)
+	if expr != nil {
+		goto end
+	}
 	switch n.Type {
 	case SubstitutionNode:
-		b.SubstitutionNode(n)
+		expr = b.SubstitutionNode(n)
 	case PointerNode:
-		b.PointerNode(n)
+		expr = b.PointerNode(n)
 	case InterfaceNode:
-		b.InterfaceNode(n)
+		expr = b.InterfaceNode(n)
 	case MapNode:
-		b.MapNode(n)
+		expr = b.MapNode(n)
 	case SliceNode:
-		b.SliceNode(n)
+		expr = b.SliceNode(n)
 	case StructNode:
-		b.StructNode(n)
+		expr = b.StructNode(n)
 	case ArrayNode:
-		b.ArrayNode(n)
+		expr = b.ArrayNode(n)
 	case StringNode:
-		b.StringNode(n)
+		expr = b.StringNode(n)
 	case BoolNode:
-		b.BoolNode(n)
+		expr = b.BoolNode(n)
 	case FuncNode:
-		b.FuncNode(n)
+		expr = b.FuncNode(n)
 	case InvalidNode:
-		b.InvalidNode(n)
+		expr = b.InvalidNode(n)
+	case BytesNode:
+		expr = b.BytesNode(n)
+	case ErrorNode:
+		expr = b.ErrorNode(n)
+	case GlobalNode:
+		expr = b.GlobalNode(n)
 	default:
 		unhandled = true
 	}
@@ -245,71 +539,66 @@ func (b *CodeBuilder) WriteCode(n *Node) {
 		goto end
 	}
 
-	//if n.Value == nil {
-	//	b.WriteString(`nil`)
-	//	goto end
-	//}
-
-	rv = reflect.ValueOf(n.Value)
-	if rv.Kind() == reflect.Ptr && rv.IsNil() {
-		b.WriteString(`""`) //TODO Verify this is what is should output, vs. `nil`
+	if n.Nil {
+		expr = b.nilExpr(n)
 		goto end
 	}
 
 	switch n.Type {
 	case IntNode:
-		b.IntNode(n)
+		expr = b.IntNode(n)
 	case Int8Node:
-		b.Int8Node(n)
+		expr = b.Int8Node(n)
 	case Int16Node:
-		b.Int16Node(n)
+		expr = b.Int16Node(n)
 	case Int32Node:
-		b.Int32Node(n)
+		expr = b.Int32Node(n)
 	case Int64Node:
-		b.Int64Node(n)
+		expr = b.Int64Node(n)
 	case UintNode:
-		b.UintNode(n)
+		expr = b.UintNode(n)
 	case Uint8Node:
-		b.Uint8Node(n)
+		expr = b.Uint8Node(n)
 	case Uint16Node:
-		b.Uint16Node(n)
+		expr = b.Uint16Node(n)
 	case Uint32Node:
-		b.Uint32Node(n)
+		expr = b.Uint32Node(n)
 	case Uint64Node:
-		b.Uint64Node(n)
+		expr = b.Uint64Node(n)
 	case Float32Node:
-		b.Float32Node(n)
+		expr = b.Float32Node(n)
 	case Float64Node:
-		b.Float64Node(n)
+		expr = b.Float64Node(n)
 	case UintptrNode:
-		b.UintptrNode(n)
+		expr = b.UintptrNode(n)
 	case UnsafePointerNode:
-		b.UnsafePointerNode(n)
+		expr = b.UnsafePointerNode(n)
 	default:
 		Panicf("Unhandled node type '%s'", n.Type)
 	}
 end:
+	b.exprNodes[expr] = n
+	return expr
 }
 
-// scalarChildWritten both determines if a Node is a scalar — or its sole
-// children are scalars where children would be the child of an Interface - and
-// if so it will write the scalar value and clear any related notes from
+// scalarChildExpr both determines if a Node is a scalar — or its sole children
+// are scalars where children would be the child of an Interface - and if so it
+// will return the scalar's expression and clear any related notes from
 // CodeBuilder.nodes to keep them from being generated as their own variables. It
-// recursively descends until it finds a scalar type. NOTE: We may augment in
-// future to handle more types if test cases emerge that help us understand that
-// we should handle them here.
-func (b *CodeBuilder) scalarChildWritten(n *Node) (written bool) {
+// recursively descends until it finds a scalar type. It returns nil if the Node
+// is not a scalar. NOTE: We may augment in future to handle more types if test
+// cases emerge that help us understand that we should handle them here.
+func (b *CodeBuilder) scalarChildExpr(n *Node) (expr ast.Expr) {
 	if n.Type == InterfaceNode && len(n.nodes) > 0 {
-		written = b.scalarChildWritten(n.nodes[0])
+		expr = b.scalarChildExpr(n.nodes[0])
 		goto end
 	}
-	if OneOf(n.Type, ScalarNodeTypes...) {
-		b.WriteCode(n)
+	if OneOf(n.Type, ScalarNodeTypes...) || OneOf(n.Type, FuncNode, ErrorNode) || n.Nil {
+		expr = b.NodeExpr(n)
 		b.genMap[reflect.ValueOf(n.Value)] = n
-		written = true
 	}
 end:
-	if written {
+	if expr != nil {
 		index, found := b.indexMap[reflect.ValueOf(n.Value)]
 		if found && index > b.Index {
 			// If the node just written was also found in list of .nodes and its index
@@ -319,254 +608,909 @@ end:
 			b.nodes[index] = nil
 		}
 	}
-	return written
+	return expr
 }
 
-func (b *CodeBuilder) refNode(n *Node) (handled bool) {
+// refNode returns the expression for a container Node that may instead need to
+// be referenced by variable name, and true if it handled the Node. If it returns
+// false the caller should generate the Node itself.
+func (b *CodeBuilder) refNode(n *Node) (expr ast.Expr, handled bool) {
+	if n.Nil || OneOf(n.Type, PointerNode, InterfaceNode) && len(n.nodes) == 0 {
+		// A nil pointer, map, slice or interface, such as a nil embedded pointer, has
+		// nothing to reference so there is no assignment to register for it.
+		expr = b.nilExpr(n)
+		handled = true
+		goto end
+	}
 	if b.nodeStack.Has(n.Id) {
+		if _, found := b.expanded[n]; !found {
+			// n is the current variable and its value is being generated.
+			b.expanded[n] = struct{}{}
+			goto end
+		}
+		// n contains itself, so assign it once its variable has been declared.
+		expr = ast.NewIdent("nil")
+		b.registerAssignment(n)
+		handled = true
 		goto end
 	}
 	b.nodeStack.Push(n.Id)
-	switch {
-	case b.Builder.Len() == b.prefixLen:
-		// Output has not been generated for any node so this is the first node and the
-		// node to which this node is a reference must have its code generated. Also,
-		// this path should only be taken once because if not we'll be in an infinite
-		// recursion. This can happen when a container contains a value that contains a
-		// pointer back to the original container.
+	if n == b.varNode {
+		// Code has not been generated for any node of the current variable so this is
+		// the first node and the node to which this node is a reference must have its
+		// code generated. Also, this path should only be taken once because if not
+		// we'll be in an infinite recursion. This can happen when a container contains
+		// a value that contains a pointer back to the original container.
 		// TODO: Make this more robust
-		b.WriteCode(n)
+		expr = b.NodeExpr(n)
 		handled = true
-
-	case b.scalarChildWritten(n):
+		goto drop
+	}
+	expr = b.scalarChildExpr(n)
+	if expr != nil {
 		handled = true
-
-	case !b.wasGenerated(n):
-		// Output has not been generated for this node which means it is being assigned
+		goto drop
+	}
+	if !b.wasGenerated(n) {
+		// Code has not been generated for this node which means it is being assigned
 		// to a property of a struct, or as an element of a map, slice or array (I think
 		// that is exhaustive of when this should run but there may be some other cases I
 		// have missed.) So just assign a nil and register that we need to generate an
 		// assignment of a pointer to the variable containing the value later.
-		b.WriteString("nil")
+		expr = ast.NewIdent("nil")
 		b.registerAssignment(n)
 		handled = true
+	}
+drop:
+	b.nodeStack.Drop()
+end:
+	return expr, handled
+}
 
-	default:
-		handled = false
+// SubstitutionNode generates the substituted code from a Node.
+func (b *CodeBuilder) SubstitutionNode(n *Node) ast.Expr {
+	return codeExpr(n.Value.(string))
+}
+
+// Int8Node generates the int8 code from a Node.
+func (b *CodeBuilder) Int8Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
+}
 
+// Int16Node generates the int16 code from a Node.
+func (b *CodeBuilder) Int16Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
+}
+
+// Int32Node generates the int32 code from a Node, or a rune literal, e.g. `'A'`,
+// when `CodeBuilder.isRune()` determines the value is a rune.
+func (b *CodeBuilder) Int32Node(n *Node) (expr ast.Expr) {
+	r := rune(reflect.ValueOf(n.Value).Int())
+	if b.isRune(n) && utf8.ValidRune(r) {
+		expr = b.namedConversion(n, runeLit(r), "int32")
+		goto end
 	}
-	b.nodeStack.Drop()
+	expr = b.integerExpr(n)
 end:
-	return handled
+	return expr
 }
 
-// SubstitutionNode generates the substituted string code from a Node using the
-// embedded `strings.Builder.`
-func (b *CodeBuilder) SubstitutionNode(n *Node) {
-	b.WriteString(n.Value.(string))
+// Int64Node generates the int64 code from a Node.
+func (b *CodeBuilder) Int64Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
 }
 
-// Int8Node generates the int8 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Int8Node(n *Node) {
-	b.WriteString(fmt.Sprintf("int8(%d)", n.Value.(int8)))
+// Uint8Node generates the uint8 code from a Node.
+func (b *CodeBuilder) Uint8Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
 }
 
-// Int16Node generates the int16 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Int16Node(n *Node) {
-	b.WriteString(fmt.Sprintf("int16(%d)", n.Value.(int16)))
+// Uint16Node generates the uint16 code from a Node.
+func (b *CodeBuilder) Uint16Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
 }
 
-// Int32Node generates the int32 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Int32Node(n *Node) {
-	b.WriteString(fmt.Sprintf("int32(%d)", n.Value.(int32)))
+// Uint32Node generates the uint32 code from a Node.
+func (b *CodeBuilder) Uint32Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
 }
 
-// Int64Node generates the int64 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Int64Node(n *Node) {
-	b.WriteString(fmt.Sprintf("int64(%d)", n.Value.(int64)))
+// Uint64Node generates the uint64 code from a Node.
+func (b *CodeBuilder) Uint64Node(n *Node) ast.Expr {
+	return b.integerExpr(n)
 }
 
-// Uint8Node generates the uint8 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Uint8Node(n *Node) {
-	b.WriteString(fmt.Sprintf("uint8(%d)", n.Value.(uint8)))
+// integerExpr generates the integer literal for the value of an integer Node,
+// converted to its type, e.g. `int8(3)`, where the type cannot be inferred and
+// is not `int`, the type of an untyped integer constant.
+func (b *CodeBuilder) integerExpr(n *Node) ast.Expr {
+	var s string
+
+	rv := reflect.ValueOf(n.Value)
+	if rv.CanInt() {
+		s = strconv.FormatInt(rv.Int(), 10)
+	} else {
+		s = strconv.FormatUint(rv.Uint(), 10)
+	}
+	return b.namedConversion(n, numberLit(token.INT, s), "int")
 }
 
-// Uint16Node generates the uint16 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Uint16Node(n *Node) {
-	b.WriteString(fmt.Sprintf("uint16(%d)", n.Value.(uint16)))
+// Float32Node generates the float32 code from a Node.
+func (b *CodeBuilder) Float32Node(n *Node) ast.Expr {
+	return b.floatExpr(n, 32)
 }
 
-// Uint32Node generates the uint32 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Uint32Node(n *Node) {
-	b.WriteString(fmt.Sprintf("uint32(%d)", n.Value.(uint32)))
+// Float64Node generates the float64 code from a Node.
+func (b *CodeBuilder) Float64Node(n *Node) ast.Expr {
+	return b.floatExpr(n, 64)
 }
 
-// Uint64Node generates the uint64 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Uint64Node(n *Node) {
-	b.WriteString(fmt.Sprintf("uint64(%d)", n.Value.(uint64)))
+// floatExpr generates the shortest float literal that reads back as the value
+// of a float Node of the size bits, converted to its type, e.g. `float32(1.5)`,
+// where the type cannot be inferred and is not `float64`, the type of an untyped
+// float constant. NaN and infinities, which have no literal, are generated as
+// `math.NaN()` and `math.Inf()`, converted to the type unless it is `float64`.
+func (b *CodeBuilder) floatExpr(n *Node, bits int) (expr ast.Expr) {
+	var typ, s string
+
+	v := reflect.ValueOf(n.Value).Float()
+	switch {
+	case math.IsNaN(v):
+		expr = callExpr(b.packageRef("math", "NaN"))
+	case math.IsInf(v, 0):
+		expr = callExpr(b.packageRef("math", "Inf"), intLit(int(math.Copysign(1, v))))
+	}
+	if expr != nil {
+		typ = b.scalarTypename(n, "float64")
+		if typ != "float64" {
+			expr = callExpr(typeExpr(typ), expr)
+		}
+		goto end
+	}
+	s = strconv.FormatFloat(v, 'g', -1, bits)
+	if !strings.ContainsAny(s, ".e") {
+		// Without a decimal point or exponent the literal would be an integer.
+		s += ".0"
+	}
+	expr = b.namedConversion(n, numberLit(token.FLOAT, s), "float64")
+end:
+	return expr
 }
 
-// Float32Node generates the float32 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Float32Node(n *Node) {
-	b.WriteString(fmt.Sprintf("float32(%f)", n.Value.(float32)))
+// conversion returns a conversion of an integer literal to the type named, e.g.
+// `int8(10)`.
+func (b *CodeBuilder) conversion(typ, value string) ast.Expr {
+	return callExpr(typeExpr(typ), numberLit(token.INT, value))
 }
 
-// Float64Node generates the float64 code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) Float64Node(n *Node) {
-	b.WriteString(fmt.Sprintf("float64(%f)", n.Value.(float64)))
+// StringNode generates the string code from a Node. Values of named string
+// types are converted to their type where it cannot be inferred, e.g.
+// `any(Color("red"))`.
+func (b *CodeBuilder) StringNode(n *Node) ast.Expr {
+	return b.namedConversion(n, b.stringExpr(reflect.ValueOf(n.Value).String()), "string")
 }
 
-// StringNode generates the string code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) StringNode(n *Node) {
-	b.WriteString(strconv.Quote(n.Value.(string)))
+// stringExpr returns the literal for s as determined by `CodeBuilder.StringFormat`
+// and `CodeBuilder.MaxStringLength`, which is a concatenation of literals if s
+// was split.
+func (b *CodeBuilder) stringExpr(s string) (expr ast.Expr) {
+	var chunks []string
+	var lits []*ast.BasicLit
+
+	raw := b.StringFormat == RawStrings && strings.Contains(s, "\n") && canBeRaw(s)
+	if b.MaxStringLength <= 0 || len(s) <= b.MaxStringLength {
+		expr = stringLitFor(s, raw)
+		goto end
+	}
+	chunks = splitLines(s, b.MaxStringLength)
+	lits = make([]*ast.BasicLit, len(chunks))
+	for i, chunk := range chunks {
+		lits[i] = stringLitFor(chunk, raw)
+	}
+	expr = b.concatExpr(lits)
+end:
+	return expr
 }
 
-// IntNode generates the Int code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) IntNode(n *Node) {
-	b.WriteString(fmt.Sprintf("%d", n.Value.(int)))
+// concatExpr returns the concatenation of lits, e.g. `"a\n" + "b\n"`, positioned
+// in `CodeBuilder.fset` so that `go/printer` prints each on its own line.
+func (b *CodeBuilder) concatExpr(lits []*ast.BasicLit) (expr ast.Expr) {
+	var size, offset int
+
+	for _, lit := range lits {
+		size += len(lit.Value) + 1
+	}
+	// Lay out the literals as if each were followed by a line break, keeping the
+	// line breaks within raw literals.
+	file := b.fset.AddFile("", -1, size)
+	for _, lit := range lits {
+		lit.ValuePos = file.Pos(offset)
+		for _, c := range []byte(lit.Value + "\n") {
+			offset++
+			if c == '\n' && offset < size {
+				file.AddLine(offset)
+			}
+		}
+		if expr == nil {
+			expr = lit
+			continue
+		}
+		expr = &ast.BinaryExpr{X: expr, Op: token.ADD, Y: lit}
+	}
+	return expr
 }
 
-// UintNode generates the Uint code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) UintNode(n *Node) {
-	b.WriteString(fmt.Sprintf("%d", n.Value.(uint)))
+// namedConversion returns lit converted to the type of n when n's type is not
+// basic, the type lit has without a conversion, e.g. `int` for an integer
+// literal, and the type cannot be inferred from where lit is used, i.e. for
+// the value returned or declared as a variable, for the values of interfaces
+// and for the arguments of `fmt.Errorf()`. Otherwise it returns lit, e.g.
+// `Color: "red"` for a struct field.
+func (b *CodeBuilder) namedConversion(n *Node, lit ast.Expr, basic string) (expr ast.Expr) {
+	expr = lit
+	typ := b.scalarTypename(n, basic)
+	if typ == basic {
+		goto end
+	}
+	if !hasDynamicType(n) && n != b.varNode {
+		goto end
+	}
+	expr = callExpr(typeExpr(typ), lit)
+end:
+	return expr
 }
 
-// BoolNode generates the bool code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) BoolNode(n *Node) {
-	b.WriteString(fmt.Sprintf("%t", n.Value.(bool)))
+// hasDynamicType returns true if there is no declared type for the value of n
+// to take on, i.e. it is the value returned, the value of an interface, or a
+// wrapped error passed to `fmt.Errorf()`.
+func hasDynamicType(n *Node) bool {
+	return n.Parent == nil || OneOf(n.Parent.Type, InterfaceNode, ErrorNode)
 }
 
-// FuncNode generates the func code from a Node using the embedded
-// `strings.Builder.`
-//
-//goland:noinspection GoUnusedParameter
-func (b *CodeBuilder) FuncNode(*Node) {
-	// TODO: Find a way to handle these so the output will compile
-	b.WriteString("func(){}")
+// nilExpr returns `nil` for a nil pointer, map, slice, func or interface Node,
+// converted to its type where there is no declared type for `nil` to take on so
+// that the dynamic type survives, e.g. `(*T)(nil)` or `[]int(nil)` within `any`.
+// A nil interface has no dynamic type so it is always `nil`.
+func (b *CodeBuilder) nilExpr(n *Node) (expr ast.Expr) {
+	var typ ast.Expr
+
+	expr = ast.NewIdent("nil")
+	if n.Type == InterfaceNode {
+		goto end
+	}
+	if !hasDynamicType(n) {
+		goto end
+	}
+	typ = typeExpr(b.resolvedTypename(n, b.qualifiedTypename(n.Typename)))
+	switch typ.(type) {
+	case *ast.StarExpr, *ast.FuncType, *ast.ChanType:
+		// Otherwise `*T(nil)` would dereference the conversion `T(nil)`.
+		typ = &ast.ParenExpr{X: typ}
+	}
+	expr = callExpr(typ, expr)
+end:
+	return expr
 }
 
-func (b *CodeBuilder) UintptrNode(n *Node) {
-	b.WriteString(fmt.Sprintf("%d", n.Value.(uintptr)))
+// scalarTypename returns the name of the type of the scalar Node n, or basic,
+// the name of the predeclared type for n's NodeType, if n's type is not named.
+func (b *CodeBuilder) scalarTypename(n *Node, basic string) (s string) {
+	s = basic
+	if n.Typename == "" || n.Typename == basic || n.Typename == "rune" {
+		goto end
+	}
+	s = b.resolvedTypename(n, b.qualifiedTypename(n.Typename))
+end:
+	return s
 }
 
-//goland:noinspection GoUnusedParameter
-func (b *CodeBuilder) UnsafePointerNode(*Node) {
-	//b.WriteString(fmt.Sprintf("%d", n.Value.UnsafePointer()))
-	// Should not output a real unsafePointer
-	// TODO: Find a way to handle this better
-	b.WriteString("-1")
+// isRune returns true if the int32 value of n should be generated as a rune.
+// reflect cannot distinguish `rune` from `int32` as `rune` is an alias, so
+// unless `CodeBuilder.Runes` is set only values declared as a rune — e.g. a
+// struct field of type `rune` or an element of `[]rune` — are detected, and only
+// when `CodeBuilder.Types` is set to look up their declarations.
+func (b *CodeBuilder) isRune(n *Node) (is bool) {
+	var p *Node
+
+	if b.Runes {
+		is = true
+		goto end
+	}
+	p = n.Parent
+	if p != nil && p.Type == ElementNode && p.Parent != nil {
+		// The elements of a slice generated as []rune("...") are runes.
+		is = isRunesType(b.declaredType(p.Parent))
+		goto end
+	}
+	is = isRuneType(b.declaredType(n))
+end:
+	return is
+}
+
+// runesConversion generates the code for a slice of runes from a Node, e.g.
+// `[]rune("héllo")`, returning false if n is not a slice of runes or has a
+// rune that cannot be represented in a string.
+func (b *CodeBuilder) runesConversion(n *Node) (expr ast.Expr, ok bool) {
+	var sb strings.Builder
+	var typ string
+
+	if len(n.nodes) == 0 {
+		goto end
+	}
+	if !b.Runes && !isRunesType(b.declaredType(n)) {
+		goto end
+	}
+	for _, node := range n.nodes {
+		elem := node.nodes[0]
+		if elem.Type != Int32Node {
+			goto end
+		}
+		r := rune(reflect.ValueOf(elem.Value).Int())
+		if !utf8.ValidRune(r) {
+			goto end
+		}
+		sb.WriteRune(r)
+	}
+	typ = b.nodeTypename(n)
+	if typ == "[]int32" {
+		typ = "[]rune"
+	}
+	expr = callExpr(typeExpr(typ), b.stringExpr(sb.String()))
+	ok = true
+end:
+	return expr, ok
+}
+
+// declaredType returns the type n was declared with, as resolved by
+// `CodeBuilder.Types`, or nil if Types is not set or the declaration is not
+// known. For struct fields this is the type of the field as written, and for the
+// elements of a slice or array it is the element type of the slice or array.
+func (b *CodeBuilder) declaredType(n *Node) (t types.Type) {
+	var rt reflect.Type
+	var p *Node
+	var err error
+
+	if b.Types == nil {
+		goto end
+	}
+	p = n.Parent
+	switch {
+	case p != nil && p.Type == FieldNode && p.Parent != nil:
+		rt = p.Parent.ReflectType()
+		if rt == nil || rt.Kind() != reflect.Struct {
+			goto end
+		}
+		t, err = b.Types.FieldType(rt, p.Index)
+	case p != nil && p.Type == ElementNode && p.Parent != nil:
+		t = b.declaredType(p.Parent)
+		if t == nil {
+			goto end
+		}
+		switch u := t.Underlying().(type) {
+		case *types.Slice:
+			t = u.Elem()
+		case *types.Array:
+			t = u.Elem()
+		default:
+			t = nil
+		}
+	default:
+		rt = n.ReflectType()
+		if rt == nil || rt.Name() == "" {
+			goto end
+		}
+		t, err = b.Types.Type(rt)
+	}
+	if err != nil {
+		t = nil
+	}
+end:
+	return t
+}
+
+// BytesNode generates the code for a `[]byte` or byte array value from a Node.
+// Printable text is generated as a string conversion, e.g. `[]byte("Hello")`,
+// otherwise as specified by `CodeBuilder.BytesFormat` and
+// `CodeBuilder.Base64Threshold`.
+func (b *CodeBuilder) BytesNode(n *Node) (expr ast.Expr) {
+	data := nodeBytes(n.Value)
+	typ := typeExpr(b.bytesTypename(n))
+	switch {
+	case n.Backing != nil:
+		expr = b.backingSliceExpr(n)
+	case len(data) == 0:
+		expr = &ast.CompositeLit{Type: typ}
+	case isPrintableText(data):
+		expr = b.bytesConversion(n, typ, b.stringExpr(string(data)))
+	case b.Base64Threshold > 0 && len(data) > b.Base64Threshold:
+		b.requireImport("encoding/base64")
+		expr = b.bytesConversion(n, typ, base64DecodeExpr(base64.StdEncoding.EncodeToString(data)))
+	case b.BytesFormat == HexBytes:
+		expr = &ast.CompositeLit{Type: typ, Elts: hexLits(data)}
+	default:
+		expr = b.bytesConversion(n, typ, stringLit(string(data)))
+	}
+	return expr
+}
+
+// bytesConversion converts x, a string literal, a concatenation of string
+// literals or an expression of type `[]byte`, to typ, the type of the BytesNode
+// n. A string can only be converted to a slice type, so for arrays — or when
+// the type's kind is unknown because n was loaded from a NodeGraph — the string
+// is first converted to `[]byte` and then to typ, e.g. `[4]byte([]byte("abcd"))`.
+func (b *CodeBuilder) bytesConversion(n *Node, typ, x ast.Expr) (expr ast.Expr) {
+	var isSlice, isString bool
+
+	switch x.(type) {
+	case *ast.BasicLit, *ast.BinaryExpr:
+		isString = true
+	}
+	rt := n.ReflectType()
+	isPlain := n.Typename == "[]uint8"
+	if isPlain || rt != nil && rt.Kind() == reflect.Slice {
+		isSlice = true
+	}
+	if isString && !isSlice {
+		x = callExpr(typeExpr("[]byte"), x)
+	}
+	expr = x
+	if isPlain && !isString {
+		// x is already a []byte.
+		goto end
+	}
+	expr = callExpr(typ, x)
+end:
+	return expr
+}
+
+// bytesTypename returns the name of the type of a BytesNode, using `byte`
+// rather than the `uint8` reflect reports for unnamed types, e.g. `[]byte` or
+// `[16]byte`.
+func (b *CodeBuilder) bytesTypename(n *Node) (s string) {
+	if bytesTypenameRE.MatchString(n.Typename) {
+		s = bytesTypenameRE.ReplaceAllString(n.Typename, "${1}byte")
+		goto end
+	}
+	s = b.resolvedTypename(n, b.qualifiedTypename(n.Typename))
+end:
+	return s
+}
+
+// IntNode generates the Int code from a Node.
+func (b *CodeBuilder) IntNode(n *Node) ast.Expr {
+	return b.integerExpr(n)
+}
+
+// UintNode generates the Uint code from a Node.
+func (b *CodeBuilder) UintNode(n *Node) ast.Expr {
+	return b.integerExpr(n)
+}
+
+// BoolNode generates the bool code from a Node.
+func (b *CodeBuilder) BoolNode(n *Node) ast.Expr {
+	return ast.NewIdent(strconv.FormatBool(n.Value.(bool)))
+}
+
+// FuncNode generates the func code from a Node. Package-level funcs are
+// referenced by name, e.g. `orders.HandleOrder`, and funcs found in
+// `CodeBuilder.Funcs` are generated as the code registered for them. Closures,
+// methods and other funcs that cannot be referenced are generated as a stub with
+// the func's signature that panics when called.
+func (b *CodeBuilder) FuncNode(n *Node) (expr ast.Expr) {
+	var pkg, rest, sig string
+
+	name := funcName(n.Value)
+	if name == "" {
+		expr = b.nilExpr(n)
+		goto end
+	}
+	sig = funcSignatureOf(n)
+	if code, found := b.Funcs[name]; found {
+		expr = codeExpr(code)
+		goto convert
+	}
+	pkg, rest = splitFuncName(name)
+	switch {
+	case pkg == "", !token.IsIdentifier(rest):
+		expr = b.funcStub(sig, name, funcKind(rest))
+	case !token.IsExported(rest) && b.packageName(pkg) != "":
+		// Unexported funcs can only be referenced from their own package.
+		expr = b.funcStub(sig, name, funcKind(rest))
+	default:
+		expr = b.packageRef(pkg, rest)
+	}
+convert:
+	expr = b.namedConversion(n, expr, sig)
+end:
+	return expr
+}
+
+// InterfaceNode generates the code for the value of an interface from a Node.
+// The value of an interface that is a field, element or map value is converted
+// to the declared interface type implicitly, so it is generated in place of the
+// interface as-is, e.g. `[]Shape{square{Side: 2}}`. Only an interface declared
+// as a variable of its own is converted explicitly, to its declared type, e.g.
+// `Shape(square{Side: 2})`; see `CodeBuilder.interfaceTypeExpr()`.
+func (b *CodeBuilder) InterfaceNode(n *Node) (expr ast.Expr) {
+	var handled bool
+
+	if n != b.varNode && len(n.nodes) > 0 {
+		expr = b.interfaceValueExpr(n)
+		goto end
+	}
+	if n == b.varNode && n.Nil {
+		expr = callExpr(b.interfaceTypeExpr(n), ast.NewIdent("nil"))
+		goto end
+	}
+	expr, handled = b.refNode(n)
+	if handled {
+		goto end
+	}
+	expr = callExpr(b.interfaceTypeExpr(n), b.NodeExpr(n.nodes[0]))
+end:
+	return expr
 }
 
-// InterfaceNode generates the `any` code from a Node using the embedded
-// `strings.Builder.` This generator generates `any` for data created as type
-// `interface{}` since there is no way in Go to differentiate that I am aware of,
-// and even if there is I don't think differentiating would be worth the effort
-// when the use-case of this project is considered.
-func (b *CodeBuilder) InterfaceNode(n *Node) {
+// interfaceValueExpr generates the value of the interface Node n in place of
+// the interface. Literals are generated as-is, and pointers, slices and maps as
+// for a field, i.e. as `nil` with an assignment to the slot of the interface
+// registered if their variables have yet to be declared. Structs and arrays that
+// must reference variables are copied when assigned, so they are assigned along
+// with the entries of maps, once the variables they reference are complete.
+func (b *CodeBuilder) interfaceValueExpr(n *Node) (expr ast.Expr) {
+	value := n.nodes[0]
+	b.inlined[n] = struct{}{}
+	expr = b.scalarChildExpr(value)
+	switch {
+	case expr != nil:
+	case isLiteral(value), OneOf(value.Type, PointerNode, SliceNode, MapNode):
+		expr = b.NodeExpr(value)
+	case b.wasGenerated(value):
+		expr = b.rhs(value)
+	default:
+		expr = ast.NewIdent("nil")
+		b.entryAssignments = append(b.entryAssignments, b.slotAssignment(value, b.rhs(value)))
+	}
+	return expr
+}
 
-	if b.refNode(n) {
+// interfaceTypeExpr returns the declared type of an interface Node, e.g.
+// `Shape`, `io.Reader` or `any`. Without a reflect.Type, e.g. for Nodes loaded
+// from a NodeGraph, it is taken from the type of the value containing the
+// interface, see `CodeBuilder.containedTypeExpr()`, or for embedded interfaces
+// is the name of the field since that is the unqualified name of the interface.
+func (b *CodeBuilder) interfaceTypeExpr(n *Node) (expr ast.Expr) {
+	rt := n.ReflectType()
+	if rt != nil && rt.Kind() == reflect.Interface {
+		expr = typeExpr(b.resolvedTypename(n, b.qualifiedTypename(rt.String())))
+		goto end
+	}
+	expr = b.containedTypeExpr(n)
+	if expr != nil {
+		goto end
+	}
+	if n.Parent != nil && n.Parent.Embedded {
+		expr = ast.NewIdent(n.Parent.Name)
 		goto end
 	}
+	expr = ast.NewIdent("any")
+end:
+	return expr
+}
 
-	// TODO: Verify that using any is sufficient, or if we need to be use named interfaces too?
-	b.WriteString("any(")
-	b.WriteCode(n.nodes[0])
-	b.WriteByte(')')
+// containedTypeExpr returns the type of n as declared by the type name of the
+// value containing it, i.e. the element type of a pointer, slice, array or map,
+// or the type of a field of an anonymous struct, or nil if it is not known.
+func (b *CodeBuilder) containedTypeExpr(n *Node) (expr ast.Expr) {
+	var container *Node
 
+	slot := n.Parent
+	if slot == nil {
+		goto end
+	}
+	container = slot
+	if slot.Type != PointerNode {
+		container = slot.Parent
+	}
+	if container == nil {
+		goto end
+	}
+	switch t := typeExpr(b.qualifiedTypename(container.Typename)).(type) {
+	case *ast.StarExpr:
+		expr = t.X
+	case *ast.ArrayType:
+		expr = t.Elt
+	case *ast.MapType:
+		expr = t.Value
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			for _, name := range field.Names {
+				if name.Name == slot.Name {
+					expr = field.Type
+				}
+			}
+		}
+	}
 end:
+	return expr
 }
 
 // PointerNode generates the pointer code for a Pointer Node
-func (b *CodeBuilder) PointerNode(n *Node) {
-	if b.refNode(n) {
+func (b *CodeBuilder) PointerNode(n *Node) (expr ast.Expr) {
+	var handled bool
+
+	if isGlobalRef(n) {
+		expr = addressOf(b.NodeExpr(n.nodes[0]))
+		goto end
+	}
+	expr, handled = b.refNode(n)
+	if handled {
 		goto end
 	}
-	b.WriteByte('&')
-	b.WriteString(b.nodeVarname(n))
+	expr = addressOf(ast.NewIdent(b.nodeVarname(n)))
 end:
+	return expr
 }
 
-// StructNode generates the struct code from a Node using the embedded
-// `strings.Builder.`
-func (b *CodeBuilder) StructNode(n *Node) {
-	b.WriteString(n.Name)
-	b.WriteByte('{')
-	for _, node := range n.nodes {
-		b.WriteString(node.Name)
-		b.WriteByte(':')
-		b.WriteCode(node.nodes[0])
-		b.WriteByte(',')
+// StructNode generates the struct code from a Node.
+func (b *CodeBuilder) StructNode(n *Node) ast.Expr {
+	elts := make([]ast.Expr, len(n.nodes))
+	for i, node := range n.nodes {
+		elts[i] = &ast.KeyValueExpr{
+			Key:   ast.NewIdent(node.Name),
+			Value: b.slotExpr(node),
+		}
+	}
+	if n != b.varNode {
+		// The struct is generated in place as the value of a field, element or map
+		// entry, so it must not also be generated as a variable.
+		b.inlined[n] = struct{}{}
 	}
-	b.WriteByte('}')
+	return compositeLit(b.nodeTypename(n), elts)
 }
 
-// MapNode generates the map code from a Node using the embedded `strings.Builder.`
-func (b *CodeBuilder) MapNode(n *Node) {
-	if b.refNode(n) {
+// MapNode generates the map code from a Node. Entries whose keys can only be
+// generated by referencing a variable, e.g. pointers, or whose values are structs
+// or arrays that contain references, are instead assigned after all variables
+// have been declared; see `CodeBuilder.mapKeyExpr()`.
+func (b *CodeBuilder) MapNode(n *Node) (expr ast.Expr) {
+	var elts []ast.Expr
+
+	expr, handled := b.refNode(n)
+	if handled {
 		goto end
 	}
 
-	b.WriteString(n.Name)
-	b.WriteByte('{')
-	for _, node := range n.nodes {
-		b.WriteCode(node)
-		b.WriteByte(':')
-		b.WriteCode(node.nodes[0])
-		b.WriteByte(',')
+	elts = make([]ast.Expr, 0, len(n.nodes))
+	for _, entry := range n.nodes {
+		key := entry
+		if entry.Type == KeyNode {
+			key = entry.nodes[1]
+		}
+		value := entry.nodes[0]
+		keyExpr, ok := b.mapKeyExpr(key)
+		switch {
+		case !ok:
+		case isLiteral(value), OneOf(value.Type, PointerNode, SliceNode, MapNode):
+			// Values that are references are generated by CodeBuilder.refNode() which
+			// registers an assignment for the entry if it has not yet been generated.
+			if key.Type != InterfaceNode {
+				keyExpr = elideType(keyExpr)
+			}
+			elts = append(elts, &ast.KeyValueExpr{
+				Key:   keyExpr,
+				Value: b.elidedSlotExpr(entry),
+			})
+			continue
+		}
+		b.entryAssignments = append(b.entryAssignments, &Assignment{
+			LHS: &ast.IndexExpr{X: ast.NewIdent(b.nodeVarname(n)), Index: keyExpr},
+			Op:  token.ASSIGN,
+			RHS: b.mapValueExpr(value),
+		})
+	}
+	expr = compositeLit(b.nodeTypename(n), elts)
+
+end:
+	return expr
+}
+
+// mapKeyExpr returns the expression for a map key, and true if it can be used
+// in a composite literal. Keys that are or contain non-nil pointers can only be
+// generated by referencing the variable declared for them, e.g. `&var2`, which
+// is returned with false.
+func (b *CodeBuilder) mapKeyExpr(key *Node) (expr ast.Expr, ok bool) {
+	switch {
+	case !isLiteral(key):
+		expr = b.rhs(key)
+	case isGlobalRef(key):
+		expr = b.NodeExpr(key)
+		ok = true
+	case key.Type == InterfaceNode && len(key.nodes) > 0:
+		// Values are converted to the interface type of the key implicitly, but
+		// their type cannot be elided.
+		b.inlined[key] = struct{}{}
+		b.inlined[key.nodes[0]] = struct{}{}
+		expr = b.NodeExpr(key.nodes[0])
+		ok = true
+	case key.Type == InterfaceNode, key.Type == PointerNode:
+		expr = ast.NewIdent("nil")
+		ok = true
+	default:
+		expr = b.NodeExpr(key)
+		ok = true
 	}
-	b.WriteByte('}')
+	return expr, ok
+}
 
+// mapValueExpr returns the expression for the value of a map entry that is
+// assigned after all variables have been declared, referencing the variable
+// declared for the value unless it is a literal.
+func (b *CodeBuilder) mapValueExpr(value *Node) (expr ast.Expr) {
+	if isLiteral(value) {
+		expr = b.NodeExpr(value)
+		goto end
+	}
+	if value.Type == InterfaceNode {
+		// The variable referenced is the one declared for the value the interface
+		// contains, so the interface must not also be declared as a variable.
+		b.inlined[value] = struct{}{}
+	}
+	expr = b.rhs(value)
 end:
+	return expr
 }
 
-// ArrayNode generates the array code from a Node using the embedded `strings.Builder.`
-func (b *CodeBuilder) ArrayNode(n *Node) {
-	b.nodeElements(n)
+// mapEntryLHS returns the left-hand side for the assignment of the value of a
+// map entry given its key, or the KeyNode wrapping its key, e.g. `var1["key"]`.
+func (b *CodeBuilder) mapEntryLHS(entry *Node) (lhs ast.Expr) {
+	key := entry
+	if key.Type == KeyNode {
+		key = key.nodes[1]
+	}
+	keyExpr, _ := b.mapKeyExpr(key)
+	return &ast.IndexExpr{
+		X:     ast.NewIdent(b.ancestorVarname(entry)),
+		Index: keyExpr,
+	}
 }
 
-// SliceNode generates the slice code from a Node using the embedded `strings.Builder.`
-func (b *CodeBuilder) SliceNode(n *Node) {
-	if b.refNode(n) {
+// slotExpr generates the expression for the value of slot, a Field or Element
+// Node or the key of a map entry, recording the slot for
+// `CodeBuilder.slotOf()`.
+func (b *CodeBuilder) slotExpr(slot *Node) ast.Expr {
+	b.slots.Push(slot)
+	defer b.slots.Drop()
+	return b.NodeExpr(slot.nodes[0])
+}
+
+// elidedSlotExpr returns the expression from `CodeBuilder.slotExpr()` with the
+// type elided, for use as an element or map value of a composite literal. The
+// type of the value of an interface is not the element type so it is kept, e.g.
+// `[]Shape{square{Side: 2}}`.
+func (b *CodeBuilder) elidedSlotExpr(slot *Node) (expr ast.Expr) {
+	expr = b.slotExpr(slot)
+	if slot.nodes[0].Type == InterfaceNode {
 		goto end
 	}
-	b.nodeElements(n)
+	expr = elideType(expr)
 end:
+	return expr
 }
 
-// nodeElements generates the element's code for both arrays and slices using the
-// embedded `strings.Builder.`
-func (b *CodeBuilder) nodeElements(n *Node) {
-	b.WriteString(n.Name)
-	b.WriteByte('{')
-	for _, node := range n.nodes {
-		b.WriteCode(node.nodes[0])
-		b.WriteByte(',')
+// slotOf returns the slot the value n is being generated for, which is n's
+// Parent unless n is shared by more than one slot.
+func (b *CodeBuilder) slotOf(n *Node) (slot *Node) {
+	var value *Node
+
+	slot = n.Parent
+	if b.slots.Empty() {
+		goto end
+	}
+	value = b.slots.Top().nodes[0]
+	if value.Type == InterfaceNode && len(value.nodes) > 0 && value.nodes[0] == n {
+		// n is the value of the interface that is the value of the slot.
+		slot = value
+		goto end
+	}
+	if value != n {
+		goto end
+	}
+	slot = b.slots.Top()
+end:
+	return slot
+}
+
+// isLiteral returns true if n can be generated as a literal, i.e. it is a scalar,
+// a pointer to a global, or a struct, array or interface that does not contain a
+// non-nil pointer, slice or map, all of which must reference the variables
+// declared for them.
+func isLiteral(n *Node) (is bool) {
+	switch n.Type {
+	case PointerNode, SliceNode, MapNode:
+		is = n.Nil || n.Type == PointerNode && len(n.nodes) == 0 || isGlobalRef(n)
+	case InterfaceNode, StructNode, ArrayNode, FieldNode, ElementNode:
+		is = true
+		for _, child := range n.nodes {
+			if !isLiteral(child) {
+				is = false
+				break
+			}
+		}
+	default:
+		is = OneOf(n.Type, ScalarNodeTypes...) || OneOf(n.Type, InvalidNode, FuncNode, ErrorNode)
+	}
+	return is
+}
+
+// ArrayNode generates the array code from a Node.
+func (b *CodeBuilder) ArrayNode(n *Node) ast.Expr {
+	if n != b.varNode {
+		// Like a struct, an array is generated in place as the value of a field,
+		// element or map entry, so it must not also be generated as a variable.
+		b.inlined[n] = struct{}{}
+	}
+	return b.nodeElements(n)
+}
+
+// SliceNode generates the slice code from a Node.
+func (b *CodeBuilder) SliceNode(n *Node) (expr ast.Expr) {
+	expr, handled := b.refNode(n)
+	if handled {
+		goto end
+	}
+	if n.Backing != nil {
+		expr = b.backingSliceExpr(n)
+		goto end
+	}
+	if b.Capacity && n.Cap > len(n.nodes) {
+		expr = b.makeSlice(n)
+		goto end
+	}
+	expr, handled = b.runesConversion(n)
+	if handled {
+		goto end
+	}
+	expr = b.nodeElements(n)
+end:
+	return expr
+}
+
+// makeSlice generates a slice whose capacity exceeds its length as a literal of
+// its elements appended to a slice made with that capacity, e.g.
+// `append(make([]int, 0, 8), []int{1, 2}...)`, or just `make([]int, 0, 8)` if it
+// is empty. The elements are appended as a literal rather than as arguments so
+// that their types can still be elided.
+func (b *CodeBuilder) makeSlice(n *Node) (expr ast.Expr) {
+	expr = callExpr(ast.NewIdent("make"), typeExpr(b.nodeTypename(n)), intLit(0), intLit(n.Cap))
+	if len(n.nodes) == 0 {
+		goto end
+	}
+	expr = &ast.CallExpr{
+		Fun:      ast.NewIdent("append"),
+		Args:     []ast.Expr{expr, b.nodeElements(n)},
+		Ellipsis: token.Pos(1), // Any valid position prints the `...`.
+	}
+end:
+	return expr
+}
+
+// nodeElements generates the element's code for both arrays and slices.
+func (b *CodeBuilder) nodeElements(n *Node) ast.Expr {
+	elts := make([]ast.Expr, len(n.nodes))
+	for i, node := range n.nodes {
+		elts[i] = b.elidedSlotExpr(node)
 	}
-	b.WriteByte('}')
+	return compositeLit(b.nodeTypename(n), elts)
 }
 
-// InvalidNode generates the `nil` for invalid Nodes using the embedded
-// `strings.Builder.` Taking a `reflect.ValueOf(nil)` will return an invalid
-// reflect type so this is appropriate, although edge cases may reveal a need to
-// handle them differently.
+// InvalidNode generates the `nil` for invalid Nodes. Taking a
+// `reflect.ValueOf(nil)` will return an invalid reflect type so this is
+// appropriate, although edge cases may reveal a need to handle them differently.
 //
 //goland:noinspection GoUnusedParameter
-func (b *CodeBuilder) InvalidNode(*Node) {
-	b.WriteString("nil")
+func (b *CodeBuilder) InvalidNode(*Node) ast.Expr {
+	return ast.NewIdent("nil")
 }
 
 // ancestorVarname looks for the varname from the Node's Parent, or its Parent,
@@ -596,7 +1540,7 @@ func (b *CodeBuilder) nodeVarname(n *Node) string {
 	if n.varname != "" {
 		goto end
 	}
-	if OneOf(n.Type, PointerNode, InterfaceNode) {
+	if OneOf(n.Type, PointerNode, InterfaceNode) && len(n.nodes) > 0 {
 		n.SetVarname(b.nodeVarname(n.nodes[0]))
 		goto end
 	}
@@ -606,45 +1550,58 @@ end:
 	return n.varname
 }
 
-// fieldLHS return the left-hand side for a struct field assignment as a string,
-// given a *Node. This will always be the var name of the parent node, e.g.
-// `var1` plus the property name of the struct to be assigned.
-func (b *CodeBuilder) fieldLHS(node *Node) (lhs string) {
-	return fmt.Sprintf("%s.%s", b.ancestorVarname(node), node.Parent.Name)
+// fieldLHS return the left-hand side for a struct field assignment, given the
+// Field Node. This will always be the var name of the parent node, e.g. `var1`
+// plus the property name of the struct to be assigned.
+func (b *CodeBuilder) fieldLHS(field *Node) (lhs ast.Expr) {
+	return &ast.SelectorExpr{
+		X:   ast.NewIdent(b.ancestorVarname(field)),
+		Sel: ast.NewIdent(field.Name),
+	}
 }
 
-// lhs return the left-hand side for an slice or array element assignment as a
-// string, given a *Node. This will always be the var name of the parent node,
+// elementLHS return the left-hand side for an slice or array element assignment,
+// given the Element Node. This will always be the var name of the parent node,
 // e.g. `var1` plus the element index of the slice/array to be assigned.
-func (b *CodeBuilder) elementLHS(node *Node) (lhs string) {
-	return fmt.Sprintf("%s[%d]", b.ancestorVarname(node), node.Index)
+func (b *CodeBuilder) elementLHS(elem *Node) (lhs ast.Expr) {
+	return &ast.IndexExpr{
+		X:     ast.NewIdent(b.ancestorVarname(elem)),
+		Index: intLit(elem.Index),
+	}
 }
 
-// assignOp will return assigment operator; an `=` if a field
-func (b *CodeBuilder) assignOp(node *Node) (op string) {
-	switch node.Parent.Type {
+// assignOp will return assigment operator for a value assigned to parent; an `=`
+// if a field or element.
+func (b *CodeBuilder) assignOp(parent *Node) (op token.Token) {
+	switch parent.Type {
 	case FieldNode, ElementNode:
-		op = "="
+		op = token.ASSIGN
 	default:
-		op = ":="
+		op = token.DEFINE
 	}
 	return op
 }
 
-// rhs return the right-hand side for an assignment as a string, given a *Node.
-// This will always be a pointer variable reference given the nature of the
-// output (or maybe not, we'll see if this assumption is wrong after we do
-// testing for more use-cases.
-func (b *CodeBuilder) rhs(node *Node) (rhs string) {
-	if b.omitAddressOf(node) {
-		return b.nodeVarname(node)
+// rhs return the right-hand side for an assignment, given a *Node. This will
+// always be a pointer variable reference given the nature of the output (or
+// maybe not, we'll see if this assumption is wrong after we do testing for more
+// use-cases.
+func (b *CodeBuilder) rhs(node *Node) (rhs ast.Expr) {
+	rhs = ast.NewIdent(b.nodeVarname(node))
+	if !b.omitAddressOf(node) {
+		rhs = addressOf(rhs)
 	}
-	return "&" + b.nodeVarname(node)
+	return rhs
 }
 
 // omitAddressOf returns true if we should omit the address of operator (&) for
 // the right-hand side.
 func (b *CodeBuilder) omitAddressOf(node *Node) (omit bool) {
+	if OneOf(node.Type, SliceNode, MapNode) {
+		// Slices and maps are references themselves, even when empty.
+		omit = true
+		goto end
+	}
 	if len(node.nodes) == 0 {
 		goto end
 	}
@@ -676,94 +1633,95 @@ end:
 }
 
 // returnVarAndType will return the return variable and its type for the node received.
-func (b *CodeBuilder) returnVarAndType(n *Node, nt NodeType) (rv, rt string) {
+func (b *CodeBuilder) returnVarAndType(n *Node, nt NodeType) (rv, rt ast.Expr) {
+	var typ string
 	switch nt {
 	case PointerNode:
-		rv += "&" + b.nodeVarname(n)
-		rt = "*" + maybeStripPackage(n.Typename, b.omitPkg)
+		rv = addressOf(ast.NewIdent(b.nodeVarname(n)))
+		typ = "*" + b.resolvedTypename(n, b.qualifiedTypename(n.Typename))
+		if n.Type == InterfaceNode {
+			// The Typename of an interface is that of its value, e.g. `any(square)`.
+			typ = "*" + exprString(b.interfaceTypeExpr(n))
+		}
 		goto end
 	case InterfaceNode:
 		fallthrough
 	default:
-		rv = b.nodeVarname(n)
-		rt = "error" // error is a built-in type that can can be nil.
+		rv = ast.NewIdent(b.nodeVarname(n))
+		typ = "error" // error is a built-in type that can can be nil.
+		if n.Type == BytesNode {
+			typ = b.bytesTypename(n)
+			goto end
+		}
 		if n.Typename != "nil" {
 			//Get the return type, and with `.omitPkg` package stripped, if applicable
-			rt = maybeStripPackage(n.Typename, b.omitPkg)
-			rt = replaceInterfaceWithAny(rt)
+			typ = b.resolvedTypename(n, b.qualifiedTypename(n.Typename))
 		}
 	}
 end:
-	return rv, rt
-}
-
-// writeAssignment will write an assigment previously registered by
-// `registerAssignment()`, which will be called in `NodeMarshaler.Build()` after
-// the *Node in which it was register for is written.
-func (b *CodeBuilder) writeAssignment(a *Assignment) {
-	b.WriteString(fmt.Sprintf("%s%s %s %s\n",
-		b.Indent,
-		a.LHS,
-		a.Op,
-		a.RHS,
-	))
+	return rv, typeExpr(typ)
 }
 
 // registerAssignment will take a node and register an assigment line to be
 // generated after the current Node is being generated in
-// `NodeMarshaler.Build()`. Assignment lines take on the form of `<LHS> <Op>
+// `CodeBuilder.BuildAST()`. Assignment lines take on the form of `<LHS> <Op>
 // <RHS>` e.g. `var1.prop = 10` or `var2 := []string{}`
 func (b *CodeBuilder) registerAssignment(n *Node) {
-	var assigned bool
-	var why string
-	var parent *Node
 	if n == nil {
 		panic("Unexpected nil Node")
 	}
-	parent = n.Parent
+	b.registerAssignmentOf(n, b.rhs(n))
+}
+
+// registerAssignmentOf registers the assignment of rhs to the slot of the Node
+// n, as for `CodeBuilder.registerAssignment()`, for when n is not referenced by
+// its variable, e.g. a `[]byte` re-slicing a backing array.
+func (b *CodeBuilder) registerAssignmentOf(n *Node, rhs ast.Expr) {
+	b.assignments = append(b.assignments, b.slotAssignment(n, rhs))
+}
+
+// slotAssignment returns the assignment of rhs to the slot of the Node n, e.g.
+// `var1.prop = &var2` or `var1[2] = var3`. The slot of a value of an interface
+// is the slot of the interface, since the value is converted to the interface
+// type implicitly when assigned.
+func (b *CodeBuilder) slotAssignment(n *Node, rhs ast.Expr) (a *Assignment) {
+	var parent *Node
+
+	parent = b.slotOf(n)
+	if parent != nil && parent.Type == UnsafePointerNode {
+		// n is the target of a followed unsafe.Pointer, so assign it converted to the
+		// slot of the unsafe.Pointer.
+		rhs = b.unsafePointerExpr(rhs)
+		parent = b.slotOf(parent)
+	}
+	if parent != nil && parent.Type == InterfaceNode {
+		parent = b.slotOf(parent)
+	}
 	if parent == nil {
 		panic("Handle when node.Parent is nil")
 	}
 	switch {
 	case parent.Type == FieldNode:
-		b.assignments = append(b.assignments, &Assignment{
-			LHS: b.fieldLHS(n),
-			Op:  b.assignOp(n),
-			RHS: b.rhs(n),
-		})
-		assigned = true
-	case parent.Type == ElementNode:
-		b.assignments = append(b.assignments, &Assignment{
-			LHS: b.elementLHS(n),
-			Op:  b.assignOp(n),
-			RHS: b.rhs(n),
-		})
-		assigned = true
-	case parent.Type == InterfaceNode:
-		// TODO: Make this more generic as we discover more test cases
-		if parent.Parent == nil {
-			why = "Parent.Parent==nil"
-			goto end
+		a = &Assignment{
+			LHS: b.fieldLHS(parent),
+			Op:  b.assignOp(parent),
+			RHS: rhs,
 		}
-		if parent.Parent.Type != SliceNode {
-			why = "Parent.Parent.Type!=SliceNode"
-			goto end
+	case parent.Type == ElementNode:
+		a = &Assignment{
+			LHS: b.elementLHS(parent),
+			Op:  b.assignOp(parent),
+			RHS: rhs,
 		}
-		if n.Type != StructNode {
-			why = "n.Type!=StructNode"
-			goto end
+	case parent.Parent != nil && parent.Parent.Type == MapNode:
+		// n is the value of a map entry and parent is its key, or a KeyNode.
+		a = &Assignment{
+			LHS: b.mapEntryLHS(parent),
+			Op:  token.ASSIGN,
+			RHS: rhs,
 		}
-		b.assignments = append(b.assignments, &Assignment{
-			LHS: fmt.Sprintf("%s[%d]", b.ancestorVarname(n), n.Index),
-			Op:  b.assignOp(n),
-			RHS: b.rhs(n),
-		})
-		assigned = true
 	default:
-		why = "👈🏽"
-	}
-end:
-	if !assigned {
-		Panicf("Node type '%s' not assigned: %s", parent.Type, why)
+		Panicf("Node type '%s' not assigned", parent.Type)
 	}
+	return a
 }
@@ -0,0 +1,423 @@
+package typegen_test
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/mikeschinkel/go-typegen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeBuilder_BuildAST(t *testing.T) {
+	m := typegen.NewNodeMarshaler(nil)
+	b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal([]int{1, 2, 3}))
+	file := b.BuildAST()
+	assert.Equal(t, "typegen_test", file.Name.Name)
+
+	fd := b.FuncDecl()
+	assert.Equal(t, "getData", fd.Name.Name)
+
+	// Rewrite the generated AST and make sure the rendering reflects it.
+	fd.Name = ast.NewIdent("getInts")
+	sb := strings.Builder{}
+	err := printer.Fprint(&sb, token.NewFileSet(), fd)
+	assert.NoError(t, err)
+	assert.Equal(t, "func getInts() []int {\n\tvar1 := []int{1, 2, 3}\n\treturn var1\n}", sb.String())
+	assert.Equal(t, "func getInts() []int {\n  var1 := []int{1, 2, 3}\n  return var1\n}", b.String())
+}
+
+func TestCodeBuilder_GenericTypenames(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		aliases map[string]string
+		want    string
+	}{
+		{
+			name:  "Type argument from package omitted",
+			value: []pair[string, pair[int, bool]]{},
+			want:  "func getData() []pair[string, pair[int, bool]] {\n  var1 := []pair[string, pair[int, bool]]{}\n  return var1\n}",
+		},
+		{
+			name:  "Type argument qualified by import path",
+			value: []pair[string, url.Values]{},
+			want:  "func getData() []pair[string, url.Values] {\n  var1 := []pair[string, url.Values]{}\n  return var1\n}",
+		},
+		{
+			name:    "Type argument with import alias",
+			value:   []pair[string, url.Values]{},
+			aliases: map[string]string{"net/url": "neturl"},
+			want:    "func getData() []pair[string, neturl.Values] {\n  var1 := []pair[string, neturl.Values]{}\n  return var1\n}",
+		},
+		{
+			name:  "Empty interface type argument",
+			value: map[string]pair[int, interface{}]{},
+			want:  "func getData() map[string]pair[int, any] {\n  var1 := map[string]pair[int, any]{}\n  return var1\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.ImportAliases = tt.aliases
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
+
+type rawBytes []byte
+
+func TestCodeBuilder_BytesNode(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     any
+		format    typegen.BytesFormat
+		threshold int
+		want      string
+	}{
+		{
+			name:   "Hex bytes",
+			value:  []byte{0x00, 0x0a, 0xff},
+			format: typegen.HexBytes,
+			want:   "func getData() []byte {\n  var1 := []byte{0x00, 0x0a, 0xff}\n  return var1\n}",
+		},
+		{
+			name:   "Printable text ignores format",
+			value:  []byte("text"),
+			format: typegen.HexBytes,
+			want:   "func getData() []byte {\n  var1 := []byte(\"text\")\n  return var1\n}",
+		},
+		{
+			name:      "Base64 above threshold",
+			value:     []byte{0x00, 0x01, 0x02, 0x03},
+			threshold: 3,
+			want:      "func getData() []byte {\n  var1 := func() []byte {\n    b, _ := base64.StdEncoding.DecodeString(\"AAECAw==\")\n    return b\n  }()\n  return var1\n}",
+		},
+		{
+			name:  "Named byte slice",
+			value: rawBytes(`{"a":1}`),
+			want:  "func getData() rawBytes {\n  var1 := rawBytes(\"{\\\"a\\\":1}\")\n  return var1\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.BytesFormat = tt.format
+			b.Base64Threshold = tt.threshold
+			assert.Equal(t, tt.want, b.String())
+			if tt.threshold > 0 {
+				assert.Equal(t, `"encoding/base64"`, b.BuildAST().Imports[0].Path.Value)
+			}
+		})
+	}
+}
+
+func TestCodeBuilder_Runes(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{
+			name:  "Rune",
+			value: 'A',
+			want:  "func getData() int32 {\n  var1 := 'A'\n  return var1\n}",
+		},
+		{
+			name:  "Escaped rune",
+			value: '\n',
+			want:  "func getData() int32 {\n  var1 := '\\n'\n  return var1\n}",
+		},
+		{
+			name:  "Slice of runes",
+			value: []rune("héllo"),
+			want:  "func getData() []int32 {\n  var1 := []rune(\"héllo\")\n  return var1\n}",
+		},
+		{
+			name:  "Invalid rune",
+			value: []rune{'a', -1},
+			want:  "func getData() []int32 {\n  var1 := []int32{'a', -1}\n  return var1\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.Runes = true
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
+
+func TestCodeBuilder_Strings(t *testing.T) {
+	const query = "SELECT *\nFROM orders\nWHERE id = 1\n"
+	tests := []struct {
+		name      string
+		value     any
+		format    typegen.StringFormat
+		maxLength int
+		want      string
+	}{
+		{
+			name:  "Quoted by default",
+			value: query,
+			want:  "func getData() string {\n  var1 := \"SELECT *\\nFROM orders\\nWHERE id = 1\\n\"\n  return var1\n}",
+		},
+		{
+			name:   "Raw multi-line string",
+			value:  query,
+			format: typegen.RawStrings,
+			want:   "func getData() string {\n  var1 := `SELECT *\nFROM orders\nWHERE id = 1\n`\n  return var1\n}",
+		},
+		{
+			name:   "Raw ignores single line string",
+			value:  "SELECT 1",
+			format: typegen.RawStrings,
+			want:   "func getData() string {\n  var1 := \"SELECT 1\"\n  return var1\n}",
+		},
+		{
+			name:   "Raw ignores string with backtick",
+			value:  "a`b\nc",
+			format: typegen.RawStrings,
+			want:   "func getData() string {\n  var1 := \"a`b\\nc\"\n  return var1\n}",
+		},
+		{
+			name:      "Split at line boundaries",
+			value:     query,
+			maxLength: 20,
+			want:      "func getData() string {\n  var1 := \"SELECT *\\n\" +\n    \"FROM orders\\n\" +\n    \"WHERE id = 1\\n\"\n  return var1\n}",
+		},
+		{
+			name:      "Split keeps lines together",
+			value:     "a\nb\nc\nd\n",
+			maxLength: 4,
+			want:      "func getData() string {\n  var1 := \"a\\nb\\n\" +\n    \"c\\nd\\n\"\n  return var1\n}",
+		},
+		{
+			name:      "Split raw printable bytes",
+			value:     []byte(query),
+			format:    typegen.RawStrings,
+			maxLength: 20,
+			want:      "func getData() []byte {\n  var1 := []byte(`SELECT *\n` +\n    `FROM orders\n` +\n    `WHERE id = 1\n`)\n  return var1\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.StringFormat = tt.format
+			b.MaxStringLength = tt.maxLength
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
+
+func TestCodeBuilder_Funcs(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      any
+		funcs      typegen.FuncRegistry
+		aliases    map[string]string
+		want       string
+		wantImport string
+	}{
+		{
+			name:  "Registered method value",
+			value: (&orderHandler{}).Handle,
+			funcs: typegen.FuncRegistry{
+				"github.com/mikeschinkel/go-typegen_test.(*orderHandler).Handle-fm": "testHandler.Handle",
+			},
+			want: "func getData() func(int) error {\n  var1 := testHandler.Handle\n  return var1\n}",
+		},
+		{
+			name:       "Func in another package",
+			value:      url.QueryEscape,
+			want:       "func getData() func(string) string {\n  var1 := url.QueryEscape\n  return var1\n}",
+			wantImport: "url",
+		},
+		{
+			name:       "Func in an aliased package",
+			value:      url.QueryEscape,
+			aliases:    map[string]string{"net/url": "neturl"},
+			want:       "func getData() func(string) string {\n  var1 := neturl.QueryEscape\n  return var1\n}",
+			wantImport: "neturl",
+		},
+		{
+			name:  "Nil func",
+			value: (func(int) error)(nil),
+			want:  "func getData() func(int) error {\n  var1 := (func(int) error)(nil)\n  return var1\n}",
+		},
+		{
+			name:  "Variadic closure",
+			value: func(string, ...int) (int, error) { return 0, nil },
+			want: "func getData() func(string, ...int) (int, error) {\n  var1 := func(string, ...int) (int, error) {\n" +
+				"    panic(\"typegen: cannot generate closure github.com/mikeschinkel/go-typegen_test.TestCodeBuilder_Funcs.func1; add it to CodeBuilder.Funcs\")\n" +
+				"  }\n  return var1\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.Funcs = tt.funcs
+			b.ImportAliases = tt.aliases
+			assert.Equal(t, tt.want, b.String())
+			if tt.wantImport == "" {
+				return
+			}
+			spec := b.BuildAST().Imports[0]
+			assert.Equal(t, `"net/url"`, spec.Path.Value)
+			if tt.wantImport != "url" {
+				assert.Equal(t, tt.wantImport, spec.Name.Name)
+			}
+		})
+	}
+}
+
+func TestCodeBuilder_UnsafePolicy(t *testing.T) {
+	n := int64(5)
+	item := &keyItem{Qty: 3}
+	tests := []struct {
+		name    string
+		value   any
+		policy  typegen.UnsafePolicy
+		targets map[string]reflect.Type
+		want    string
+		imports []string
+	}{
+		{
+			name:  "Nil pointers",
+			value: &unsafeHolder{},
+			want: "func getData() *unsafeHolder {\n" +
+				"  var1 := unsafeHolder{Nil: nil, Buf: nil, Addr: uintptr(0), Any: nil}\n" +
+				"  return &var1\n}",
+		},
+		{
+			name:   "Placeholders",
+			value:  &unsafeHolder{Buf: unsafe.Pointer(&n), Addr: uintptr(unsafe.Pointer(&n))},
+			policy: typegen.PlaceholderUnsafe,
+			want: "func getData() *unsafeHolder {\n" +
+				"  var1 := unsafeHolder{Nil: nil, Buf: typegen.Placeholder[unsafe.Pointer](\".Buf\"), Addr: typegen.Placeholder[uintptr](\".Addr\"), Any: nil}\n" +
+				"  return &var1\n}",
+			imports: []string{`"github.com/mikeschinkel/go-typegen"`, `"unsafe"`},
+		},
+		{
+			name:    "Followed pointers",
+			value:   &unsafeHolder{Nil: unsafe.Pointer(item), Buf: unsafe.Pointer(&n)},
+			targets: map[string]reflect.Type{".Nil": reflect.TypeOf(keyItem{}), ".Buf": reflect.TypeOf(n)},
+			want: "func getData() *unsafeHolder {\n" +
+				"  var1 := unsafeHolder{Nil: nil, Buf: nil, Addr: uintptr(0), Any: nil}\n" +
+				"  var2 := keyItem{Qty: 3}\n" +
+				"  var3 := int64(5)\n" +
+				"  var1.Nil = unsafe.Pointer(&var2)\n" +
+				"  var1.Buf = unsafe.Pointer(&var3)\n" +
+				"  return &var1\n}",
+			imports: []string{`"unsafe"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			m.UnsafePointerTypes = tt.targets
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.UnsafePolicy = tt.policy
+			assert.Equal(t, tt.want, b.String())
+			var imports []string
+			for _, spec := range b.BuildAST().Imports {
+				imports = append(imports, spec.Path.Value)
+			}
+			assert.Equal(t, tt.imports, imports)
+		})
+	}
+}
+
+func TestCodeBuilder_Capacity(t *testing.T) {
+	ints := make([]int, 2, 8)
+	ints[0] = 1
+	tests := []struct {
+		name     string
+		value    any
+		capacity bool
+		want     string
+	}{
+		{
+			name:     "Slice with spare capacity",
+			value:    ints,
+			capacity: true,
+			want:     "func getData() []int {\n  var1 := append(make([]int, 0, 8), []int{1, 0}...)\n  return var1\n}",
+		},
+		{
+			name:     "Empty slice with capacity",
+			value:    make([]string, 0, 4),
+			capacity: true,
+			want:     "func getData() []string {\n  var1 := make([]string, 0, 4)\n  return var1\n}",
+		},
+		{
+			name:     "Slice of structs with spare capacity",
+			value:    append(make([]keyPoint, 0, 3), keyPoint{X: 1, Y: 2}),
+			capacity: true,
+			want:     "func getData() []keyPoint {\n  var1 := append(make([]keyPoint, 0, 3), []keyPoint{{X: 1, Y: 2}}...)\n  return var1\n}",
+		},
+		{
+			name:     "Full slice",
+			value:    []int{1, 2},
+			capacity: true,
+			want:     "func getData() []int {\n  var1 := []int{1, 2}\n  return var1\n}",
+		},
+		{
+			name:  "Capacity ignored by default",
+			value: ints,
+			want:  "func getData() []int {\n  var1 := []int{1, 0}\n  return var1\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(typegen.NewNodeMarshaler(nil).Marshal(tt.value))
+			if !assert.NoError(t, err) {
+				return
+			}
+			var nodes typegen.Nodes
+			err = json.Unmarshal(data, &nodes)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, ns := range []typegen.Nodes{typegen.NewNodeMarshaler(nil).Marshal(tt.value), nodes} {
+				b := typegen.NewCodeBuilder("getData", "typegen_test", ns)
+				b.Capacity = tt.capacity
+				assert.Equal(t, tt.want, b.String())
+			}
+		})
+	}
+}
+
+type byteSize uint64
+
+type quota struct {
+	Limit byteSize
+	Used  byteSize
+	Free  byteSize
+}
+
+func TestCodeBuilder_Formatters(t *testing.T) {
+	m := typegen.NewNodeMarshaler(nil)
+	b := typegen.NewCodeBuilder("getQuota", "typegen_test", m.Marshal(&quota{Limit: 8 << 30, Used: 12 << 10, Free: 1000}))
+	b.Formatters = map[string]typegen.UnitFormatter{
+		"typegen_test.byteSize": typegen.Units{
+			{Name: "github.com/acme/units.KiB", Size: 1 << 10},
+			{Name: "github.com/acme/units.GiB", Size: 1 << 30},
+		},
+	}
+	want := "func getQuota() *quota {\n" +
+		"  var1 := quota{Limit: 8 * units.GiB, Used: 12 * units.KiB, Free: 1000}\n" +
+		"  return &var1\n}"
+	assert.Equal(t, want, b.String())
+	assert.Equal(t, `"github.com/acme/units"`, b.BuildAST().Imports[0].Path.Value)
+}
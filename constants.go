@@ -0,0 +1,232 @@
+package typegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/mikeschinkel/go-diffator"
+	. "github.com/mikeschinkel/go-lib"
+)
+
+// constantNodeTypes are the NodeTypes of values that may equal a constant.
+var constantNodeTypes = append([]NodeType{StringNode}, integerNodeTypes...)
+
+// stringerConstant returns the name of the constant rv equals as returned by its
+// String() method, e.g. `Red`, or for a flag set the names of the flags joined
+// by `|`, e.g. `FlagA|FlagB`. It returns an empty string if rv is not of a
+// defined integer or string type, String() echoes the value itself, or any name
+// is not that of a constant declared with the type, and referenceable per
+// `TypeResolver.Constants()`, that together with the others equals rv.
+func (m *NodeMarshaler) stringerConstant(rv *reflect.Value) (name string) {
+	var s fmt.Stringer
+	var consts []*types.Const
+	var parts []string
+	var echo string
+	var v, sum constant.Value
+	var ok bool
+	var err error
+
+	if !rv.IsValid() || rv.Type().Name() == "" || rv.Type().PkgPath() == "" {
+		goto end
+	}
+	if !OneOf(NodeType(rv.Kind()), constantNodeTypes...) {
+		goto end
+	}
+	s, ok = diffator.NewReflector(rv).Any().(fmt.Stringer)
+	if !ok {
+		goto end
+	}
+	v = constantValue(rv)
+	echo = v.ExactString()
+	if v.Kind() == constant.String {
+		echo = constant.StringVal(v)
+	}
+	if s.String() == echo {
+		goto end
+	}
+	consts, err = m.typeResolver().Constants(rv.Type())
+	if err != nil {
+		goto end
+	}
+	parts = strings.Split(s.String(), "|")
+	if len(parts) > 1 && v.Kind() != constant.Int {
+		goto end
+	}
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		index := slices.IndexFunc(consts, func(c *types.Const) bool {
+			return c.Name() == parts[i]
+		})
+		if index == -1 {
+			goto end
+		}
+		if sum == nil {
+			sum = consts[index].Val()
+			continue
+		}
+		sum = constant.BinaryOp(sum, token.OR, consts[index].Val())
+	}
+	if !constant.Compare(sum, token.EQL, v) {
+		goto end
+	}
+	name = strings.Join(parts, "|")
+end:
+	return name
+}
+
+// typeResolver returns `NodeMarshaler.Types`, first setting it to a
+// TypeResolver for use outside of any package if not set.
+func (m *NodeMarshaler) typeResolver() *TypeResolver {
+	if m.Types == nil {
+		m.Types = NewTypeResolver("")
+	}
+	return m.Types
+}
+
+// constantValue returns the value of rv, of an integer or string kind, as a
+// constant.Value to compare with the values of declared constants.
+func constantValue(rv *reflect.Value) (v constant.Value) {
+	switch {
+	case rv.Kind() == reflect.String:
+		v = constant.MakeString(rv.String())
+	case rv.CanInt():
+		v = constant.MakeInt64(rv.Int())
+	default:
+		v = constant.MakeUint64(rv.Uint())
+	}
+	return v
+}
+
+// constantExpr generates the value of a scalar Node of a defined type as the
+// constant it equals, e.g. `paint.Red`, or as the flag constants it combines,
+// e.g. `paint.Bold | paint.Italic`, or returns nil if it equals no constants.
+func (b *CodeBuilder) constantExpr(n *Node) (expr ast.Expr) {
+	for _, name := range b.constantNames(n) {
+		x := codeExpr(name)
+		if expr == nil {
+			expr = x
+			continue
+		}
+		expr = &ast.BinaryExpr{X: expr, Op: token.OR, Y: x}
+	}
+	return expr
+}
+
+// constantNames returns the names of the constants the value of n equals or
+// combines. They are looked up by `CodeBuilder.Types`, if set and the type of n
+// is known, otherwise taken from `Node.Constant` and qualified with the package
+// name of n's type.
+func (b *CodeBuilder) constantNames(n *Node) (names []string) {
+	var typ string
+
+	if n.Nil || !OneOf(n.Type, constantNodeTypes...) {
+		goto end
+	}
+	if b.Types != nil && n.ReflectType() != nil {
+		names = b.declaredConstantNames(n)
+		goto end
+	}
+	if n.Constant == "" {
+		goto end
+	}
+	typ = b.qualifiedTypename(n.Typename)
+	typ = typ[:strings.LastIndexByte(typ, '.')+1]
+	for _, name := range strings.Split(n.Constant, "|") {
+		names = append(names, typ+name)
+	}
+end:
+	return names
+}
+
+// declaredConstantNames returns the name of the constant declared with the type
+// of n that n equals, or if there is none and the constants are a flag set, the
+// names of the flags that together make up n, in the order declared.
+func (b *CodeBuilder) declaredConstantNames(n *Node) (names []string) {
+	var consts, flags []*types.Const
+	var rv reflect.Value
+	var v constant.Value
+	var bits, covered uint64
+	var err error
+
+	consts, err = b.Types.Constants(n.ReflectType())
+	if err != nil || len(consts) == 0 {
+		goto end
+	}
+	rv = reflect.ValueOf(n.Value)
+	v = constantValue(&rv)
+	for _, c := range consts {
+		if constant.Compare(c.Val(), token.EQL, v) {
+			names = []string{b.Types.ObjectString(c)}
+			goto end
+		}
+	}
+	if v.Kind() != constant.Int || constant.Sign(v) <= 0 || !isFlagSet(consts) {
+		goto end
+	}
+	bits, _ = constant.Uint64Val(v)
+	for _, c := range consts {
+		flag, exact := constant.Uint64Val(c.Val())
+		if !exact || flag == 0 || flag&(flag-1) != 0 {
+			// Only constants with a single bit set are flags.
+			continue
+		}
+		if bits&flag != flag || covered&flag != 0 {
+			continue
+		}
+		flags = append(flags, c)
+		covered |= flag
+	}
+	if covered != bits {
+		goto end
+	}
+	for _, c := range flags {
+		names = append(names, b.Types.ObjectString(c))
+	}
+end:
+	return names
+}
+
+// isFlagSet returns true if consts, the constants of a type, are flags, i.e.
+// there are at least two constants with a single bit set and every other
+// non-zero constant combines some of them, e.g. a mask. Constants with the
+// consecutive values of an enum declared with iota, e.g. 0, 1 and 2, are not.
+func isFlagSet(consts []*types.Const) (is bool) {
+	var values []uint64
+	var bits uint64
+	var flags int
+	var gap bool
+
+	for _, c := range consts {
+		v, exact := constant.Uint64Val(c.Val())
+		if !exact {
+			goto end
+		}
+		values = append(values, v)
+		if v != 0 && v&(v-1) == 0 {
+			bits |= v
+			flags++
+		}
+	}
+	if flags < 2 {
+		goto end
+	}
+	slices.Sort(values)
+	values = slices.Compact(values)
+	for i, v := range values {
+		if v&^bits != 0 {
+			goto end
+		}
+		if i > 0 && v != values[i-1]+1 {
+			gap = true
+		}
+	}
+	is = gap
+end:
+	return is
+}
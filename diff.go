@@ -0,0 +1,502 @@
+package typegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strings"
+
+	. "github.com/mikeschinkel/go-lib"
+)
+
+// NodeDiffer compares two marshaled values and generates the Assignments that
+// would turn the first value into the second, e.g. `var1.Orders[2].Qty = 5`.
+// This makes it easy to see only what changed between, for example, a passing
+// run and a failing run.
+type NodeDiffer struct {
+	// RootVarname is the name of the variable assumed to hold the first value.
+	// It defaults to `var1` which is the name `CodeBuilder` gives to the root
+	// value it generates.
+	RootVarname string
+
+	// Types and ImportAliases determine the type names of the composite literals
+	// in the Assignments, see `CodeBuilder.Types` and `CodeBuilder.ImportAliases`.
+	Types         *TypeResolver
+	ImportAliases map[string]string
+
+	// omitPkg is the package name to be stripped from all types, see
+	// `CodeBuilder.omitPkg`.
+	omitPkg string
+
+	// builder is used to generate the expressions for scalar values.
+	builder *CodeBuilder
+
+	// aSeen and bSeen record the left-hand side of the first path at which each
+	// pointer Node in the first and second value was reached, so that pointers
+	// can be aligned by identity and not just by path.
+	aSeen map[*Node]ast.Expr
+	bSeen map[*Node]ast.Expr
+
+	assignments Assignments
+}
+
+// NewNodeDiffer instantiates a new *NodeDiffer. omitPkg is the package name to
+// omit from types, just as for `NewCodeBuilder()`.
+func NewNodeDiffer(omitPkg string) *NodeDiffer {
+	return &NodeDiffer{
+		RootVarname: "var1",
+		omitPkg:     omitPkg,
+	}
+}
+
+// DiffNodes returns the Assignments that would turn the value marshaled as a
+// into the value marshaled as b. See `NodeDiffer.Diff()`.
+func DiffNodes(a, b Nodes) (Assignments, error) {
+	return NewNodeDiffer("").Diff(a, b)
+}
+
+// Diff aligns the two Node trees by path — and for pointers by identity — and
+// returns the Assignments that would turn the value marshaled as a into the
+// value marshaled as b. Paths are rooted at `NodeDiffer.RootVarname`, which is
+// assumed to hold the value a points to if a is a pointer, just as
+// `CodeBuilder` generates it.
+func (d *NodeDiffer) Diff(a, b Nodes) (as Assignments, err error) {
+	var an, bn *Node
+
+	d.builder = NewCodeBuilder("", d.omitPkg, nil)
+	d.builder.Types = d.Types
+	d.builder.ImportAliases = d.ImportAliases
+	d.aSeen = make(map[*Node]ast.Expr)
+	d.bSeen = make(map[*Node]ast.Expr)
+	d.assignments = make(Assignments, 0)
+
+	an = rootNode(a)
+	bn = rootNode(b)
+	if an == nil || bn == nil {
+		err = fmt.Errorf("cannot diff empty Nodes")
+		goto end
+	}
+	err = d.diff(an, bn, ast.NewIdent(d.RootVarname), true)
+	if err != nil {
+		goto end
+	}
+	as = d.assignments
+end:
+	return as, err
+}
+
+// rootNode returns the root Node of the Nodes returned by
+// `NodeMarshaler.Marshal()`, dereferenced if it is a non-nil pointer.
+func rootNode(ns Nodes) (n *Node) {
+	if len(ns) < 2 {
+		goto end
+	}
+	n = ns[1]
+	if n.Type == PointerNode && len(n.nodes) > 0 {
+		n = n.nodes[0]
+	}
+end:
+	return n
+}
+
+// diff compares a and b found at lhs and registers the Assignments needed to
+// turn a into b. addressable is false for values that cannot be partially
+// assigned, such as structs stored in maps, and thus must be replaced whole.
+func (d *NodeDiffer) diff(a, b *Node, lhs ast.Expr, addressable bool) (err error) {
+	var replace bool
+
+	if b.Type == PointerNode && len(b.nodes) > 0 {
+		replace, err = d.alignPointers(a, b, lhs)
+		if err != nil || !replace {
+			goto end
+		}
+	}
+	if a.Type != b.Type || a.Typename != b.Typename || a.Nil != b.Nil {
+		// Includes a nil slice or map becoming empty, or vice versa.
+		err = d.replace(lhs, b)
+		goto end
+	}
+	switch b.Type {
+	case PointerNode:
+		err = d.diffPointer(a, b, lhs)
+	case StructNode:
+		err = d.diffStruct(a, b, lhs, addressable)
+	case ArrayNode:
+		err = d.diffArray(a, b, lhs, addressable)
+	case SliceNode:
+		err = d.diffSlice(a, b, lhs)
+	case MapNode:
+		err = d.diffMap(a, b, lhs)
+	default:
+		// Scalars, and interfaces which cannot be partially assigned.
+		if nodesEqual(a, b, make(map[*Node]struct{})) {
+			goto end
+		}
+		err = d.replace(lhs, b)
+	}
+end:
+	return err
+}
+
+// alignPointers handles non-nil pointers in b that were already reached by
+// another path, which are assigned from that path rather than diffed again.
+// It returns true if the pointer still needs to be diffed by path.
+func (d *NodeDiffer) alignPointers(a, b *Node, lhs ast.Expr) (diff bool, err error) {
+	bPrev, bFound := d.bSeen[b]
+	aPrev, aFound := d.aSeen[a]
+	if bFound {
+		if aFound && exprString(aPrev) == exprString(bPrev) {
+			// Both point to the same thing they pointed to at a prior path, so they were
+			// already diffed there.
+			goto end
+		}
+		d.assign(lhs, bPrev)
+		goto end
+	}
+	d.bSeen[b] = lhs
+	if aFound {
+		// a is shared with a prior path, but b is not, so diffing what a points to
+		// would change the value at that prior path too. Replace a instead.
+		err = d.replace(lhs, b)
+		goto end
+	}
+	if a.Type == PointerNode {
+		d.aSeen[a] = lhs
+	}
+	diff = true
+end:
+	return diff, err
+}
+
+// diffPointer compares two pointers which may each be nil.
+func (d *NodeDiffer) diffPointer(a, b *Node, lhs ast.Expr) (err error) {
+	var elem *Node
+	switch {
+	case len(a.nodes) == 0 && len(b.nodes) == 0:
+		// Both are nil
+	case len(a.nodes) == 0 || len(b.nodes) == 0:
+		err = d.replace(lhs, b)
+	default:
+		elem = b.nodes[0]
+		err = d.diff(a.nodes[0], elem, derefLHS(lhs, elem), true)
+	}
+	return err
+}
+
+// diffStruct compares two structs field by field.
+func (d *NodeDiffer) diffStruct(a, b *Node, lhs ast.Expr, addressable bool) (err error) {
+	if !addressable {
+		if !nodesEqual(a, b, make(map[*Node]struct{})) {
+			err = d.replace(lhs, b)
+		}
+		goto end
+	}
+	for i, field := range b.nodes {
+		err = d.diff(a.nodes[i].nodes[0], field.nodes[0], &ast.SelectorExpr{
+			X:   lhs,
+			Sel: ast.NewIdent(field.Name),
+		}, true)
+		if err != nil {
+			goto end
+		}
+	}
+end:
+	return err
+}
+
+// diffArray compares two arrays element by element.
+func (d *NodeDiffer) diffArray(a, b *Node, lhs ast.Expr, addressable bool) (err error) {
+	if !addressable {
+		if !nodesEqual(a, b, make(map[*Node]struct{})) {
+			err = d.replace(lhs, b)
+		}
+		goto end
+	}
+	err = d.diffElements(a.nodes, b.nodes, lhs)
+end:
+	return err
+}
+
+// diffSlice compares two slices element by element, truncating or appending to
+// the slice when their lengths differ.
+func (d *NodeDiffer) diffSlice(a, b *Node, lhs ast.Expr) (err error) {
+	var extra []ast.Expr
+	var elts Nodes
+
+	aLen, bLen := len(a.nodes), len(b.nodes)
+	if bLen < aLen {
+		d.assign(lhs, &ast.SliceExpr{X: lhs, High: intLit(bLen)})
+	}
+	err = d.diffElements(a.nodes[:min(aLen, bLen)], b.nodes[:min(aLen, bLen)], lhs)
+	if err != nil {
+		goto end
+	}
+	if bLen <= aLen {
+		goto end
+	}
+	elts = b.nodes[aLen:]
+	extra = make([]ast.Expr, len(elts))
+	for i, elt := range elts {
+		extra[i], err = d.literal(elt.nodes[0], make(map[*Node]struct{}))
+		if err != nil {
+			goto end
+		}
+	}
+	d.assign(lhs, callExpr(ast.NewIdent("append"), append([]ast.Expr{lhs}, extra...)...))
+end:
+	return err
+}
+
+// diffElements compares the Element Nodes of two arrays or slices of the same
+// length.
+func (d *NodeDiffer) diffElements(as, bs Nodes, lhs ast.Expr) (err error) {
+	for i, elt := range bs {
+		err = d.diff(as[i].nodes[0], elt.nodes[0], &ast.IndexExpr{
+			X:     lhs,
+			Index: intLit(i),
+		}, true)
+		if err != nil {
+			goto end
+		}
+	}
+end:
+	return err
+}
+
+// diffMap compares two maps entry by entry, aligning entries by key. Since
+// removing an entry cannot be expressed as an assignment, the whole map is
+// replaced if b is missing keys found in a.
+func (d *NodeDiffer) diffMap(a, b *Node, lhs ast.Expr) (err error) {
+	var value, keyExpr ast.Expr
+
+	aKeys := make(map[string]*Node, len(a.nodes))
+	bKeys := make(map[string]*Node, len(b.nodes))
+	for _, key := range a.nodes {
+		keyExpr, err = d.keyExpr(key)
+		if err != nil {
+			goto end
+		}
+		aKeys[exprString(keyExpr)] = key
+	}
+	for _, key := range b.nodes {
+		keyExpr, err = d.keyExpr(key)
+		if err != nil {
+			goto end
+		}
+		bKeys[exprString(keyExpr)] = key
+	}
+	for k := range aKeys {
+		if _, found := bKeys[k]; !found {
+			err = d.replace(lhs, b)
+			goto end
+		}
+	}
+	for _, key := range b.nodes {
+		keyExpr, err = d.keyExpr(key)
+		if err != nil {
+			goto end
+		}
+		entry := &ast.IndexExpr{X: lhs, Index: keyExpr}
+		aKey, found := aKeys[exprString(keyExpr)]
+		if found {
+			err = d.diff(aKey.nodes[0], key.nodes[0], entry, false)
+			if err != nil {
+				goto end
+			}
+			continue
+		}
+		value, err = d.literal(key.nodes[0], make(map[*Node]struct{}))
+		if err != nil {
+			goto end
+		}
+		d.assign(entry, value)
+	}
+end:
+	return err
+}
+
+// keyExpr returns the literal for the key of a map entry, which is the entry's
+// Node itself unless it is a KeyNode wrapping the key.
+func (d *NodeDiffer) keyExpr(entry *Node) (expr ast.Expr, err error) {
+	if entry.Type != KeyNode {
+		expr = d.builder.NodeExpr(entry)
+		goto end
+	}
+	expr, err = d.literal(entry.nodes[1], make(map[*Node]struct{}))
+end:
+	return expr, err
+}
+
+// replace registers an Assignment of the literal value of n to lhs.
+func (d *NodeDiffer) replace(lhs ast.Expr, n *Node) (err error) {
+	rhs, err := d.literal(n, make(map[*Node]struct{}))
+	if err != nil {
+		goto end
+	}
+	d.assign(lhs, rhs)
+end:
+	return err
+}
+
+// assign registers an Assignment of rhs to lhs.
+func (d *NodeDiffer) assign(lhs, rhs ast.Expr) {
+	d.assignments = append(d.assignments, &Assignment{
+		LHS: lhs,
+		Op:  token.ASSIGN,
+		RHS: rhs,
+	})
+}
+
+// literal returns an expression that creates the value of n inline, i.e.
+// without declaring any variables. Values that refer back to themselves cannot
+// be created inline, so an error is returned for them.
+func (d *NodeDiffer) literal(n *Node, seen map[*Node]struct{}) (expr ast.Expr, err error) {
+	var elts []ast.Expr
+	var elem, keyExpr ast.Expr
+
+	if _, found := seen[n]; found {
+		err = fmt.Errorf("cannot generate an inline literal for '%s' since it refers to itself", n.Typename)
+		goto end
+	}
+	seen[n] = struct{}{}
+	defer delete(seen, n)
+
+	if n.Nil {
+		expr = ast.NewIdent("nil")
+		goto end
+	}
+	switch n.Type {
+	case PointerNode:
+		if len(n.nodes) == 0 {
+			expr = ast.NewIdent("nil")
+			goto end
+		}
+		elem, err = d.literal(n.nodes[0], seen)
+		if err != nil {
+			goto end
+		}
+		if OneOf(n.nodes[0].Type, StructNode, ArrayNode, SliceNode, MapNode) {
+			expr = addressOf(elem)
+			goto end
+		}
+		// Go cannot take the address of a scalar literal, so use a func literal to
+		// declare a variable and return its address.
+		expr = callExpr(&ast.FuncLit{
+			Type: &ast.FuncType{
+				Params:  &ast.FieldList{},
+				Results: &ast.FieldList{List: []*ast.Field{{Type: typeExpr(d.typename(n))}}},
+			},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("v")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{elem},
+				},
+				&ast.ReturnStmt{Results: []ast.Expr{addressOf(ast.NewIdent("v"))}},
+			}},
+		})
+	case InterfaceNode:
+		if len(n.nodes) == 0 {
+			expr = ast.NewIdent("nil")
+			goto end
+		}
+		expr, err = d.literal(n.nodes[0], seen)
+	case StructNode:
+		elts = make([]ast.Expr, len(n.nodes))
+		for i, field := range n.nodes {
+			elem, err = d.literal(field.nodes[0], seen)
+			if err != nil {
+				goto end
+			}
+			elts[i] = &ast.KeyValueExpr{Key: ast.NewIdent(field.Name), Value: elem}
+		}
+		expr = compositeLit(d.typename(n), elts)
+	case ArrayNode, SliceNode:
+		elts = make([]ast.Expr, len(n.nodes))
+		for i, elt := range n.nodes {
+			elts[i], err = d.literal(elt.nodes[0], seen)
+			if err != nil {
+				goto end
+			}
+		}
+		expr = compositeLit(d.typename(n), elts)
+	case MapNode:
+		elts = make([]ast.Expr, len(n.nodes))
+		for i, key := range n.nodes {
+			elem, err = d.literal(key.nodes[0], seen)
+			if err != nil {
+				goto end
+			}
+			keyExpr, err = d.keyExpr(key)
+			if err != nil {
+				goto end
+			}
+			elts[i] = &ast.KeyValueExpr{Key: keyExpr, Value: elem}
+		}
+		expr = compositeLit(d.typename(n), elts)
+	default:
+		expr = d.builder.NodeExpr(n)
+	}
+end:
+	return expr, err
+}
+
+// typename returns the type name of n as `CodeBuilder.nodeTypename()` would
+// generate it.
+func (d *NodeDiffer) typename(n *Node) string {
+	return d.builder.nodeTypename(n)
+}
+
+// derefLHS returns the expression to assign to the value a pointer at lhs
+// points to. Fields of structs and elements of arrays are dereferenced
+// implicitly by Go, but slices, maps and scalars must be dereferenced
+// explicitly.
+func derefLHS(lhs ast.Expr, elem *Node) (expr ast.Expr) {
+	switch elem.Type {
+	case StructNode, ArrayNode:
+		expr = lhs
+	case SliceNode, MapNode:
+		expr = &ast.ParenExpr{X: &ast.StarExpr{X: lhs}}
+	default:
+		expr = &ast.StarExpr{X: lhs}
+	}
+	return expr
+}
+
+// nodesEqual returns true if the values represented by a and b are equal.
+func nodesEqual(a, b *Node, seen map[*Node]struct{}) (equal bool) {
+	if a.Type != b.Type || a.Typename != b.Typename || a.Nil != b.Nil || len(a.nodes) != len(b.nodes) {
+		goto end
+	}
+	if a.Type == FieldNode && a.Name != b.Name {
+		goto end
+	}
+	if OneOf(a.Type, append(ScalarNodeTypes, ErrorNode)...) && !reflect.DeepEqual(a.Value, b.Value) {
+		goto end
+	}
+	if _, found := seen[a]; found {
+		// a refers back to itself; assume equal since the rest of the value decides.
+		equal = true
+		goto end
+	}
+	seen[a] = struct{}{}
+	for i := range a.nodes {
+		if !nodesEqual(a.nodes[i], b.nodes[i], seen) {
+			goto end
+		}
+	}
+	equal = true
+end:
+	return equal
+}
+
+// exprString renders an ast.Expr as Go source code.
+func exprString(expr ast.Expr) string {
+	sb := strings.Builder{}
+	err := printerConfig("").Fprint(&sb, token.NewFileSet(), expr)
+	if err != nil {
+		Panicf("Unable to render expression: %s", err)
+	}
+	return sb.String()
+}
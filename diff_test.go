@@ -0,0 +1,103 @@
+package typegen_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/mikeschinkel/go-typegen"
+	"github.com/stretchr/testify/assert"
+)
+
+type diffItem struct {
+	Sku string
+	Qty int
+}
+
+type diffOrder struct {
+	Customer *diffItem
+	Items    []diffItem
+	Tags     map[string]int
+	Note     any
+	Cells    map[gridPoint]int
+}
+
+func TestDiffNodes(t *testing.T) {
+	tests := []struct {
+		name string
+		a    any
+		b    any
+		want string
+	}{
+		{
+			name: "Equal values",
+			a:    &diffOrder{Items: []diffItem{{Sku: "A", Qty: 1}}},
+			b:    &diffOrder{Items: []diffItem{{Sku: "A", Qty: 1}}},
+			want: "",
+		},
+		{
+			name: "Changed element field",
+			a:    &diffOrder{Items: []diffItem{{Sku: "A", Qty: 1}, {Sku: "B", Qty: 2}}},
+			b:    &diffOrder{Items: []diffItem{{Sku: "A", Qty: 1}, {Sku: "B", Qty: 5}}},
+			want: "var1.Items[1].Qty = 5\n",
+		},
+		{
+			name: "Appended and added map entry",
+			a:    &diffOrder{Items: []diffItem{{Sku: "A"}}, Tags: map[string]int{"a": 1}},
+			b:    &diffOrder{Items: []diffItem{{Sku: "A"}, {Sku: "B"}}, Tags: map[string]int{"a": 1, "b": 2}},
+			want: "var1.Items = append(var1.Items, diffItem{Sku: \"B\", Qty: 0})\n" +
+				"var1.Tags[\"b\"] = 2\n",
+		},
+		{
+			name: "Truncated slice and removed map entry",
+			a:    &diffOrder{Items: []diffItem{{Sku: "A"}, {Sku: "B"}}, Tags: map[string]int{"a": 1, "b": 2}},
+			b:    &diffOrder{Items: []diffItem{{Sku: "C"}}, Tags: map[string]int{"b": 2}},
+			want: "var1.Items = var1.Items[:1]\n" +
+				"var1.Items[0].Sku = \"C\"\n" +
+				"var1.Tags = map[string]int{\"b\": 2}\n",
+		},
+		{
+			name: "Pointer set and interface changed",
+			a:    &diffOrder{Note: "hello"},
+			b:    &diffOrder{Customer: &diffItem{Sku: "Z"}, Note: 10},
+			want: "var1.Customer = &diffItem{Sku: \"Z\", Qty: 0}\n" +
+				"var1.Note = 10\n",
+		},
+		{
+			name: "Changed and added struct keyed map entries",
+			a:    &diffOrder{Cells: map[gridPoint]int{{X: 0, Y: 1}: 1}},
+			b:    &diffOrder{Cells: map[gridPoint]int{{X: 0, Y: 1}: 2, {X: 1, Y: 1}: 3}},
+			want: "var1.Cells[gridPoint{X: 0, Y: 1}] = 2\n" +
+				"var1.Cells[gridPoint{X: 1, Y: 1}] = 3\n",
+		},
+		{
+			name: "Nil slice made empty and map made nil",
+			a:    &diffOrder{Tags: map[string]int{"a": 1}},
+			b:    &diffOrder{Items: []diffItem{}},
+			want: "var1.Items = []diffItem{}\n" +
+				"var1.Tags = nil\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := typegen.NewNodeMarshaler(nil).Marshal(tt.a)
+			b := typegen.NewNodeMarshaler(nil).Marshal(tt.b)
+			as, err := typegen.NewNodeDiffer("typegen_test").Diff(a, b)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.want, as.String())
+		})
+	}
+}
+
+func TestNodeDiffer_ImportAliases(t *testing.T) {
+	a := typegen.NewNodeMarshaler(nil).Marshal(&diffOrder{})
+	b := typegen.NewNodeMarshaler(nil).Marshal(&diffOrder{Note: []url.Values{}})
+	d := typegen.NewNodeDiffer("typegen_test")
+	d.ImportAliases = map[string]string{"url": "neturl"}
+	as, err := d.Diff(a, b)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "var1.Note = []neturl.Values{}\n", as.String())
+}
@@ -0,0 +1,299 @@
+package typegen
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mikeschinkel/go-diffator"
+	. "github.com/mikeschinkel/go-lib"
+)
+
+// SentinelRegistry maps sentinel errors to the names of the package-level
+// variables that hold them, qualified by import path, e.g. `io.EOF` or
+// `github.com/acme/orders.ErrNotFound`. Errors are found by identity, so an
+// error is only generated by name if it is the very error the variable holds.
+type SentinelRegistry map[error]string
+
+// StdSentinels lists the sentinel errors of the standard library that are
+// generated by name. It is consulted after `NodeMarshaler.Sentinels`.
+var StdSentinels = SentinelRegistry{
+	io.EOF:                   "io.EOF",
+	io.ErrUnexpectedEOF:      "io.ErrUnexpectedEOF",
+	io.ErrShortWrite:         "io.ErrShortWrite",
+	io.ErrShortBuffer:        "io.ErrShortBuffer",
+	io.ErrNoProgress:         "io.ErrNoProgress",
+	io.ErrClosedPipe:         "io.ErrClosedPipe",
+	os.ErrNotExist:           "os.ErrNotExist",
+	os.ErrExist:              "os.ErrExist",
+	os.ErrPermission:         "os.ErrPermission",
+	os.ErrClosed:             "os.ErrClosed",
+	os.ErrInvalid:            "os.ErrInvalid",
+	os.ErrDeadlineExceeded:   "os.ErrDeadlineExceeded",
+	context.Canceled:         "context.Canceled",
+	context.DeadlineExceeded: "context.DeadlineExceeded",
+	strconv.ErrRange:         "strconv.ErrRange",
+	strconv.ErrSyntax:        "strconv.ErrSyntax",
+	errors.ErrUnsupported:    "errors.ErrUnsupported",
+}
+
+// errorType is the reflect.Type of the `error` interface.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// isErrorValue returns true if the interface value rv holds an error.
+func isErrorValue(rv *reflect.Value) (is bool) {
+	if rv.IsNil() {
+		goto end
+	}
+	is = rv.Elem().Type().Implements(errorType)
+end:
+	return is
+}
+
+// marshalError marshals an error held by an `error` interface, or the root
+// value, whose concrete type cannot be generated, returning nil for values it
+// does not handle. See `NodeMarshaler.errorNode()`.
+func (m *NodeMarshaler) marshalError(rv *reflect.Value, parent *Node) (node *Node) {
+	var err error
+
+	if !rv.IsValid() || rv.Kind() == reflect.Interface || !rv.Type().Implements(errorType) {
+		goto end
+	}
+	if parent != nil && !OneOf(parent.Type, InterfaceNode, ErrorNode) {
+		// A value whose declared type is its concrete type must keep that type.
+		goto end
+	}
+	if isNilValue(rv) {
+		goto end
+	}
+	err, _ = diffator.NewReflector(rv).Any().(error)
+	if err == nil {
+		goto end
+	}
+	node = m.errorNode(err, parent)
+end:
+	return node
+}
+
+// errorNode returns a GlobalNode for a sentinel error, or an ErrorNode for an
+// error of an unexported type such as `*errors.errorString`, or nil for other
+// errors, which are marshaled as-is. The Value of an ErrorNode is its message
+// unless it wraps errors, in which case its Value is the format that
+// `fmt.Errorf()` produces its message from, e.g. `read config: %w`, and its
+// children are the wrapped errors: sentinels, errors of unexported types, and
+// values of exported types that can be generated in place, e.g. `&MyErr{Code:
+// 1}`. If any wrapped error is none of these only the message is generated.
+func (m *NodeMarshaler) errorNode(err error, parent *Node) (node *Node) {
+	var wrapped []error
+	var format string
+	var children Nodes
+	var ok bool
+
+	name, found := m.sentinelName(err)
+	if found {
+		node = m.NewNode(&NodeArgs{
+			Name:      name,
+			Type:      GlobalNode,
+			Value:     name,
+			Typename:  "error",
+			marshaler: m,
+			Parent:    parent,
+		})
+		goto end
+	}
+	if !isUnexportedType(reflect.TypeOf(err)) {
+		goto end
+	}
+	node = m.NewNode(&NodeArgs{
+		Name:      strconv.Quote(err.Error()),
+		Type:      ErrorNode,
+		Value:     err.Error(),
+		Typename:  "error",
+		marshaler: m,
+		Parent:    parent,
+	})
+	wrapped = unwrapErrors(err)
+	if len(wrapped) == 0 {
+		goto end
+	}
+	format, ok = errorFormat(err.Error(), wrapped)
+	if !ok {
+		goto end
+	}
+	for _, w := range wrapped {
+		child := m.errorNode(w, node)
+		if child == nil {
+			child = m.wrappedValueNode(w, node)
+		}
+		if child == nil {
+			// The wrapped error cannot be generated in place, so generate only the
+			// message.
+			goto end
+		}
+		children = append(children, child)
+	}
+	node.Value = format
+	for _, child := range children {
+		node.AddNode(child)
+	}
+end:
+	return node
+}
+
+// wrappedValueNode marshals the wrapped error err, of an exported type, as a
+// value of its own type to be generated in place as an argument of
+// `fmt.Errorf()`, or returns nil if err, or the value it points to, holds a
+// non-nil pointer, slice, map, interface, func or channel, which would need to
+// reference a variable. A pointer is not registered so that it is generated as
+// the address of its value, e.g. `&MyErr{Code: 1}`, rather than as a variable.
+func (m *NodeMarshaler) wrappedValueNode(err error, parent *Node) (node *Node) {
+	var elem reflect.Value
+
+	rv := reflect.ValueOf(err)
+	if rv.Kind() != reflect.Pointer {
+		if isInPlaceValue(rv) {
+			node = m.marshalValue(&rv, parent)
+		}
+		goto end
+	}
+	elem = rv.Elem()
+	if !isInPlaceValue(elem) {
+		goto end
+	}
+	node = m.NewNode(&NodeArgs{
+		Name:         rv.Type().String(),
+		marshaler:    m,
+		ReflectValue: &rv,
+		Parent:       parent,
+	})
+	node.AddNode(m.marshalValue(&elem, node))
+end:
+	return node
+}
+
+// isInPlaceValue returns true if rv holds no non-nil pointers, slices, maps,
+// interfaces, funcs or channels, and so can be generated in place.
+func isInPlaceValue(rv reflect.Value) (is bool) {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		is = rv.IsNil()
+	case reflect.UnsafePointer:
+	case reflect.Struct:
+		is = true
+		for i := 0; i < rv.NumField() && is; i++ {
+			is = isInPlaceValue(rv.Field(i))
+		}
+	case reflect.Array:
+		is = true
+		for i := 0; i < rv.Len() && is; i++ {
+			is = isInPlaceValue(rv.Index(i))
+		}
+	default:
+		is = true
+	}
+	return is
+}
+
+// sentinelName returns the name of the sentinel error err as found in
+// `NodeMarshaler.Sentinels` or StdSentinels, and true if found.
+func (m *NodeMarshaler) sentinelName(err error) (name string, found bool) {
+	if !reflect.TypeOf(err).Comparable() {
+		// Looking up an error that is not comparable would panic.
+		goto end
+	}
+	name, found = m.Sentinels[err]
+	if found {
+		goto end
+	}
+	name, found = StdSentinels[err]
+end:
+	return name, found
+}
+
+// isUnexportedType returns true if rt, or the type it points to, is a named type
+// that is not exported and thus cannot be referenced outside its package.
+func isUnexportedType(rt reflect.Type) (is bool) {
+	if rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	is = rt.Name() != "" && rt.PkgPath() != "" && !token.IsExported(rt.Name())
+	return is
+}
+
+// unwrapErrors returns the errors err wraps, as returned by its `Unwrap() error`
+// or `Unwrap() []error` method, or nil if it wraps none or any are nil.
+func unwrapErrors(err error) (wrapped []error) {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		wrapped = []error{e.Unwrap()}
+	case interface{ Unwrap() []error }:
+		wrapped = e.Unwrap()
+	}
+	for _, w := range wrapped {
+		if w == nil {
+			wrapped = nil
+			break
+		}
+	}
+	return wrapped
+}
+
+// errorFormat returns the format that reproduces msg when passed to
+// `fmt.Errorf()` with the wrapped errors, i.e. msg with the message of each
+// wrapped error, in order, replaced by `%w` and any other `%` escaped, and true
+// if the message of every wrapped error was found.
+func errorFormat(msg string, wrapped []error) (format string, ok bool) {
+	var sb strings.Builder
+
+	for _, w := range wrapped {
+		index := strings.Index(msg, w.Error())
+		if index == -1 {
+			goto end
+		}
+		sb.WriteString(strings.ReplaceAll(msg[:index], "%", "%%"))
+		sb.WriteString("%w")
+		msg = msg[index+len(w.Error()):]
+	}
+	sb.WriteString(strings.ReplaceAll(msg, "%", "%%"))
+	format = sb.String()
+	ok = true
+end:
+	return format, ok
+}
+
+// ErrorNode generates the code for an error from a Node, e.g. `errors.New("not
+// found")`, or for an error that wraps others `fmt.Errorf("read config: %w",
+// io.EOF)` or `fmt.Errorf("code 5: %w", &MyErr{Code: 1})`.
+func (b *CodeBuilder) ErrorNode(n *Node) (expr ast.Expr) {
+	var args []ast.Expr
+
+	if len(n.nodes) == 0 {
+		expr = callExpr(b.packageRef("errors", "New"), b.stringExpr(n.Value.(string)))
+		goto end
+	}
+	args = []ast.Expr{b.stringExpr(n.Value.(string))}
+	for _, child := range n.nodes {
+		if child.Type == PointerNode {
+			// A wrapped error of an exported pointer type is generated in place.
+			args = append(args, addressOf(b.NodeExpr(child.nodes[0])))
+			continue
+		}
+		args = append(args, b.NodeExpr(child))
+	}
+	expr = callExpr(b.packageRef("fmt", "Errorf"), args...)
+end:
+	return expr
+}
+
+// GlobalNode generates a reference to a package-level variable from a Node,
+// e.g. `io.EOF`.
+func (b *CodeBuilder) GlobalNode(n *Node) ast.Expr {
+	pkg, ident := splitFuncName(n.Value.(string))
+	return b.packageRef(pkg, ident)
+}
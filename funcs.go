@@ -0,0 +1,173 @@
+package typegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// FuncRegistry maps the names `runtime.FuncForPC()` reports for func values to
+// the code to generate for them. It allows closures and method values, which
+// cannot be referenced by name, to be generated as an expression that is valid
+// where the generated code is used, e.g.:
+//
+//	typegen.FuncRegistry{
+//		"github.com/acme/orders.(*Server).Handle-fm": "testServer.Handle",
+//		"github.com/acme/orders.NewRouter.func1":     "orders.NotFound",
+//	}
+type FuncRegistry map[string]string
+
+// funcName returns the name `runtime.FuncForPC()` reports for the func value of
+// a FuncNode, e.g. `github.com/acme/orders.HandleOrder`, or an empty string if
+// the func is nil. Nodes loaded from a NodeGraph already have the name as their
+// Value.
+func funcName(v any) (name string) {
+	var rv reflect.Value
+	var fn *runtime.Func
+
+	if s, ok := v.(string); ok {
+		name = s
+		goto end
+	}
+	rv = reflect.ValueOf(v)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		goto end
+	}
+	fn = runtime.FuncForPC(rv.Pointer())
+	if fn == nil {
+		goto end
+	}
+	name = fn.Name()
+end:
+	return name
+}
+
+// splitFuncName splits a name returned by funcName() into the import path of
+// its package and the remainder, e.g. `HandleOrder`, `(*Server).Handle-fm` or
+// `NewRouter.func1`. The runtime escapes dots in the last element of the import
+// path, e.g. `gopkg.in/yaml%2ev3`, so that the first dot after the last slash
+// ends the path.
+func splitFuncName(name string) (pkg, rest string) {
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot == -1 {
+		rest = name
+		goto end
+	}
+	pkg = strings.ReplaceAll(name[:slash+1+dot], "%2e", ".")
+	rest = name[slash+2+dot:]
+end:
+	return pkg, rest
+}
+
+// funcKind describes the func named by rest, as returned by splitFuncName(), for
+// the message of the stub generated for funcs that cannot be referenced.
+func funcKind(rest string) (kind string) {
+	switch {
+	case strings.HasSuffix(rest, "-fm"):
+		kind = "method value"
+	case strings.HasPrefix(rest, "("), strings.Contains(rest, ")."):
+		kind = "method"
+	case strings.Contains(rest, "["):
+		kind = "generic func"
+	case token.IsIdentifier(rest):
+		kind = "unexported func"
+	default:
+		kind = "closure"
+	}
+	return kind
+}
+
+// funcSignature returns the signature of the func type rt as reflect would
+// format it were rt not named, e.g. `func(int, ...string) (bool, error)`.
+func funcSignature(rt reflect.Type) string {
+	var sb strings.Builder
+
+	sb.WriteString("func(")
+	for i := 0; i < rt.NumIn(); i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if rt.IsVariadic() && i == rt.NumIn()-1 {
+			sb.WriteString("...")
+			sb.WriteString(rt.In(i).Elem().String())
+			continue
+		}
+		sb.WriteString(rt.In(i).String())
+	}
+	sb.WriteString(")")
+	switch rt.NumOut() {
+	case 0:
+	case 1:
+		sb.WriteString(" ")
+		sb.WriteString(rt.Out(0).String())
+	default:
+		sb.WriteString(" (")
+		for i := 0; i < rt.NumOut(); i++ {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(rt.Out(i).String())
+		}
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+// funcSignatureOf returns the signature of the func type of the FuncNode n, or
+// an empty string if it is not known, which is the case for Nodes of named func
+// types loaded from a NodeGraph.
+func funcSignatureOf(n *Node) (sig string) {
+	rt := n.ReflectType()
+	if rt != nil && rt.Kind() == reflect.Func {
+		sig = funcSignature(rt)
+		goto end
+	}
+	if strings.HasPrefix(n.Typename, "func(") {
+		sig = n.Typename
+	}
+end:
+	return sig
+}
+
+// packageRef returns an expression that references the package-level func or
+// variable ident declared in the package with import path pkg, e.g.
+// `orders.HandleOrder` or `io.EOF`, and records the import it needs.
+func (b *CodeBuilder) packageRef(pkg, ident string) (expr ast.Expr) {
+	name := b.packageName(pkg)
+	expr = ast.NewIdent(ident)
+	if name == "" {
+		goto end
+	}
+	b.requireImport(pkg)
+	expr = &ast.SelectorExpr{X: ast.NewIdent(name), Sel: ast.NewIdent(ident)}
+end:
+	return expr
+}
+
+// funcStub returns a func literal with the signature sig whose body panics with
+// a message naming the func it stands in for, since closures and methods cannot
+// be referenced by name. If sig is not known it returns `nil`.
+func (b *CodeBuilder) funcStub(sig, name, kind string) (expr ast.Expr) {
+	var msg string
+
+	ft, ok := typeExpr(b.qualifiedTypename(sig)).(*ast.FuncType)
+	if !ok {
+		expr = ast.NewIdent("nil")
+		goto end
+	}
+	msg = fmt.Sprintf("typegen: cannot generate %s %s; add it to CodeBuilder.Funcs", kind, name)
+	expr = &ast.FuncLit{
+		Type: ft,
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ExprStmt{X: callExpr(ast.NewIdent("panic"), stringLit(msg))},
+			},
+		},
+	}
+end:
+	return expr
+}
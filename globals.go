@@ -0,0 +1,82 @@
+package typegen
+
+import (
+	"reflect"
+
+	. "github.com/mikeschinkel/go-lib"
+)
+
+// global is a package-level variable registered by
+// `NodeMarshaler.RegisterGlobal()`.
+type global struct {
+	name string
+	ptr  reflect.Value
+}
+
+// RegisterGlobal registers the package-level variable named name, qualified by
+// import path, e.g. `github.com/acme/orders.DefaultOptions`, where ptr is a
+// pointer to it, e.g. `&orders.DefaultOptions`. Pointers to the variable are
+// then generated as references to it, e.g. `&orders.DefaultOptions`, rather
+// than as pointers to a copy of its value. A variable of type `error` is also
+// added to `NodeMarshaler.Sentinels` so that errors it holds are generated by
+// name, e.g. `orders.ErrNotFound`.
+func (m *NodeMarshaler) RegisterGlobal(name string, ptr any) {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		Panicf("RegisterGlobal() requires a pointer to the variable '%s'; got %T", name, ptr)
+	}
+	m.globals = append(m.globals, global{name: name, ptr: rv})
+	if rv.Elem().Type() != errorType || rv.Elem().IsNil() {
+		goto end
+	}
+	if m.Sentinels == nil {
+		m.Sentinels = make(SentinelRegistry)
+	}
+	m.Sentinels[rv.Elem().Interface().(error)] = name
+end:
+}
+
+// addGlobals adds a pointer Node for each registered global to `.ptrMap` so
+// that `NodeMarshaler.isRegistered()` finds pointers to the global. The child
+// of each is a GlobalNode that names the global.
+func (m *NodeMarshaler) addGlobals() {
+	for _, g := range m.globals {
+		node := m.NewNode(&NodeArgs{
+			Name:         "&" + g.name,
+			marshaler:    m,
+			ReflectValue: &g.ptr,
+		})
+		node.AddNode(m.NewNode(&NodeArgs{
+			Name:      g.name,
+			Type:      GlobalNode,
+			Value:     g.name,
+			Typename:  g.ptr.Type().Elem().String(),
+			marshaler: m,
+			Parent:    node,
+		}))
+		m.ptrMap[g.ptr.Pointer()] = node
+	}
+}
+
+// isGlobalRef returns true if n is a pointer to a global registered by
+// `NodeMarshaler.RegisterGlobal()`.
+func isGlobalRef(n *Node) bool {
+	return n != nil && n.Type == PointerNode && len(n.nodes) > 0 && n.nodes[0].Type == GlobalNode
+}
+
+// holdsGlobalPointer returns true if the interface value rv holds a pointer to a
+// global registered by `NodeMarshaler.RegisterGlobal()`.
+func (m *NodeMarshaler) holdsGlobalPointer(rv *reflect.Value) (is bool) {
+	var elem reflect.Value
+	var n *Node
+	var found bool
+
+	if rv.IsNil() || rv.Elem().Kind() != reflect.Pointer {
+		goto end
+	}
+	elem = rv.Elem()
+	n, found = m.ptrMap[elem.Pointer()]
+	is = found && isGlobalRef(n) && n.ReflectType() == elem.Type()
+end:
+	return is
+}
@@ -1,15 +1,18 @@
 module github.com/mikeschinkel/go-typegen
 
-go 1.21
+go 1.22.0
 
 require (
 	github.com/mikeschinkel/go-diffator v0.0.0-20240106010639-56550da6a1bd
 	github.com/mikeschinkel/go-lib v0.0.0-20240106005120-5f93962a57d4
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/tools v0.26.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/mod v0.21.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
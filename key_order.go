@@ -0,0 +1,211 @@
+package typegen
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxCompareDepth limits how deep compareValues() recurses through pointers,
+// which guards against cycles between the values being compared.
+const maxCompareDepth = 8
+
+// sortedEntries returns the keys of the map rv, and the values for them, in a
+// deterministic order so that the code generated for a map is identical across
+// runs; see compareKeys(). Keys that compare equal, e.g. distinct pointers to
+// equal values or NaNs, are ordered by their values, then by address so that
+// the order of entries that are alike in every other way does not depend on
+// the random order of `reflect.Value.MapRange()`.
+func (m *NodeMarshaler) sortedEntries(rv *reflect.Value) (keys, values []reflect.Value) {
+	var order []int
+
+	iter := rv.MapRange()
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+		values = append(values, iter.Value())
+		order = append(order, len(order))
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		c := m.compareKeys(keys[a], keys[b])
+		if c == 0 {
+			c = m.compareValues(values[a], values[b], 0)
+		}
+		if c == 0 {
+			c = compareAddresses(keys[a], keys[b])
+		}
+		return c < 0
+	})
+	keys, values = permute(keys, order), permute(values, order)
+	return keys, values
+}
+
+// permute returns the elements of vs in the order of the indexes in order.
+func permute(vs []reflect.Value, order []int) (permuted []reflect.Value) {
+	permuted = make([]reflect.Value, len(vs))
+	for i, index := range order {
+		permuted[i] = vs[index]
+	}
+	return permuted
+}
+
+// compareAddresses orders keys that are pointers, or interfaces holding them,
+// by address, which is the only way to tell apart distinct pointers to equal
+// values that are not otherwise referenced. Other keys compare equal.
+func compareAddresses(a, b reflect.Value) (c int) {
+	if a.Kind() == reflect.Interface && !a.IsNil() && !b.IsNil() {
+		a, b = a.Elem(), b.Elem()
+	}
+	if a.Kind() != reflect.Pointer || b.Kind() != reflect.Pointer {
+		goto end
+	}
+	c = compareUints(uint64(a.Pointer()), uint64(b.Pointer()))
+end:
+	return c
+}
+
+// compareKeys compares two map keys returning -1, 0 or +1. Numbers are ordered
+// numerically, strings lexicographically, and arrays and structs element by
+// element and field by field. Pointers already visited by the NodeMarshaler are
+// ordered by when they were first visited, before pointers not yet visited
+// which are ordered by the values they point to.
+func (m *NodeMarshaler) compareKeys(a, b reflect.Value) int {
+	return m.compareValues(a, b, 0)
+}
+
+// compareValues does the work for compareKeys(), recursing into composite
+// values.
+func (m *NodeMarshaler) compareValues(a, b reflect.Value, depth int) (c int) {
+	switch {
+	case !a.IsValid() || !b.IsValid():
+		c = compareBools(a.IsValid(), b.IsValid())
+		goto end
+	case a.Kind() != b.Kind():
+		c = compareInts(int64(a.Kind()), int64(b.Kind()))
+		goto end
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		c = compareBools(a.Bool(), b.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c = compareInts(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		c = compareUints(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		c = compareFloats(a.Float(), b.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c = compareFloats(real(a.Complex()), real(b.Complex()))
+		if c == 0 {
+			c = compareFloats(imag(a.Complex()), imag(b.Complex()))
+		}
+	case reflect.String:
+		c = strings.Compare(a.String(), b.String())
+	case reflect.Array, reflect.Slice:
+		c = compareInts(int64(a.Len()), int64(b.Len()))
+		for i := 0; c == 0 && i < a.Len(); i++ {
+			c = m.compareValues(a.Index(i), b.Index(i), depth)
+		}
+	case reflect.Struct:
+		for i := 0; c == 0 && i < a.NumField(); i++ {
+			c = m.compareValues(a.Field(i), b.Field(i), depth)
+		}
+	case reflect.Interface:
+		c = m.compareInterfaces(a, b, depth)
+	case reflect.Pointer:
+		c = m.comparePointers(a, b, depth)
+	case reflect.Map:
+		c = compareInts(int64(a.Len()), int64(b.Len()))
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		c = compareBools(!a.IsNil(), !b.IsNil())
+	}
+end:
+	return c
+}
+
+// compareInterfaces orders nil interfaces first, then by the name of the type
+// of the value they contain, then by the value.
+func (m *NodeMarshaler) compareInterfaces(a, b reflect.Value, depth int) (c int) {
+	c = compareBools(!a.IsNil(), !b.IsNil())
+	if c != 0 || a.IsNil() {
+		goto end
+	}
+	c = strings.Compare(a.Elem().Type().String(), b.Elem().Type().String())
+	if c != 0 {
+		goto end
+	}
+	c = m.compareValues(a.Elem(), b.Elem(), depth)
+end:
+	return c
+}
+
+// comparePointers orders nil pointers first, then pointers already visited by
+// when they were first visited, i.e. by the Id of their Node, then pointers not
+// yet visited by the values they point to.
+func (m *NodeMarshaler) comparePointers(a, b reflect.Value, depth int) (c int) {
+	var an, bn *Node
+	var aFound, bFound bool
+
+	c = compareBools(!a.IsNil(), !b.IsNil())
+	if c != 0 || a.IsNil() || a.Pointer() == b.Pointer() {
+		goto end
+	}
+	an, aFound = m.ptrMap[a.Pointer()]
+	bn, bFound = m.ptrMap[b.Pointer()]
+	switch {
+	case aFound && bFound:
+		c = compareInts(int64(an.Id), int64(bn.Id))
+	case aFound != bFound:
+		c = compareBools(bFound, aFound)
+	case depth < maxCompareDepth:
+		c = m.compareValues(a.Elem(), b.Elem(), depth+1)
+	}
+end:
+	return c
+}
+
+// compareBools orders false before true.
+func compareBools(a, b bool) (c int) {
+	switch {
+	case a == b:
+	case b:
+		c = -1
+	default:
+		c = 1
+	}
+	return c
+}
+
+func compareInts(a, b int64) (c int) {
+	switch {
+	case a < b:
+		c = -1
+	case a > b:
+		c = 1
+	}
+	return c
+}
+
+func compareUints(a, b uint64) (c int) {
+	switch {
+	case a < b:
+		c = -1
+	case a > b:
+		c = 1
+	}
+	return c
+}
+
+// compareFloats orders numerically, with NaNs — which can each be a distinct map
+// key — after all numbers.
+func compareFloats(a, b float64) (c int) {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		c = compareBools(math.IsNaN(a), math.IsNaN(b))
+	case a < b:
+		c = -1
+	case a > b:
+		c = 1
+	}
+	return c
+}
@@ -3,6 +3,7 @@ package typegen
 import (
 	"fmt"
 	"reflect"
+	"strconv"
 
 	"github.com/mikeschinkel/go-diffator"
 	. "github.com/mikeschinkel/go-lib"
@@ -24,6 +25,7 @@ type NodeArgs struct {
 	Index        int
 	Parent       *Node
 	Typename     string
+	Embedded     bool
 }
 
 type Node struct {
@@ -38,6 +40,30 @@ type Node struct {
 	Index       int
 	varname     string
 	debugString string
+
+	// Embedded is true for a FieldNode of an embedded field, e.g. `Base` in
+	// `struct{ Base; Name string }`, whose Name is the name of its type.
+	Embedded bool
+
+	// Nil is true for a nil pointer, map, slice, func or interface, which
+	// distinguishes a nil slice or map from an empty one since neither has child
+	// Nodes.
+	Nil bool
+
+	// Cap is the capacity of a slice, which may exceed its length.
+	Cap int
+
+	// Constant is the name of the constant that the value of a scalar Node of a
+	// defined type equals, e.g. `Red`, or the names of the flag constants it
+	// combines joined by `|`, as returned by the value's String() method. See
+	// `NodeMarshaler.UseStringer`.
+	Constant string
+
+	// Backing is the array Node of a slice that shares its backing array with
+	// other slices, e.g. `buf` for `buf[2:8]`, and Offset is the index within it of
+	// the slice's first element. See `NodeMarshaler.shareBackingArrays()`.
+	Backing *Node
+	Offset  int
 }
 
 func NewNode(id int, args *NodeArgs) (n *Node) {
@@ -54,12 +80,14 @@ func NewNode(id int, args *NodeArgs) (n *Node) {
 		Value:     args.Value,
 		Marshaler: args.marshaler,
 		Index:     args.Index,
+		Embedded:  args.Embedded,
 		Parent:    args.Parent,
 	}
 	if args.ReflectValue != nil {
 		r := diffator.NewReflector(args.ReflectValue)
 		n.Value = r.Any()
 		n.Typename = r.Typename()
+		n.Nil = isNilValue(args.ReflectValue)
 	}
 
 	if n.Typename == "" {
@@ -71,6 +99,15 @@ func NewNode(id int, args *NodeArgs) (n *Node) {
 	return n
 }
 
+// isNilValue returns true if rv is a nil pointer, map, slice, func or interface.
+func isNilValue(rv *reflect.Value) (is bool) {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Interface:
+		is = rv.IsNil()
+	}
+	return is
+}
+
 func (n *Node) String() (s string) {
 	if n != nil {
 		s = fmt.Sprintf("%s %sNode [Id: %d, Index: %d]", n.Name, n.Type, n.Id, n.Index)
@@ -138,3 +175,71 @@ end:
 func (n *Node) Nodes() Nodes {
 	return n.nodes
 }
+
+// ReflectType returns the reflect.Type of the value the Node was marshaled from,
+// or nil if unknown, e.g. for Field and Element Nodes, or for Nodes loaded from
+// a NodeGraph which have no NodeMarshaler.
+func (n *Node) ReflectType() (rt reflect.Type) {
+	if n.Marshaler == nil {
+		goto end
+	}
+	rt = n.Marshaler.reflectTypes[n.Id]
+end:
+	return rt
+}
+
+// Path returns the logical Go path from the root value to this Node, e.g.
+// `.Orders[2].Customer`, by walking up the chain of Parents. Pointers and
+// interfaces are transparent, fields contribute `.<Name>`, elements contribute
+// `[<index>]` and map values contribute `[<key>]`. The root Node returns an
+// empty string.
+func (n *Node) Path() string {
+	return n.path(make(map[*Node]struct{}))
+}
+
+// path does the work for Path(). The seen map guards against cycles in the chain
+// of Parents which can occur when a container contains a pointer back to itself.
+func (n *Node) path(seen map[*Node]struct{}) (s string) {
+	var p *Node
+
+	if n == nil || n.Parent == nil {
+		goto end
+	}
+	if _, found := seen[n]; found {
+		goto end
+	}
+	seen[n] = struct{}{}
+	p = n.Parent
+	switch {
+	case p.Type == FieldNode:
+		s = p.path(seen) + "." + p.Name
+	case p.Type == ElementNode:
+		s = fmt.Sprintf("%s[%d]", p.path(seen), p.Index)
+	case p.Type == MapNode:
+		// n is a map key, so report the path of the map entry it is the key for.
+		s = fmt.Sprintf("%s[%s]", p.path(seen), n.keyString())
+	case p.Parent != nil && p.Parent.Type == MapNode:
+		// n is a map value and p is its key, or p is a KeyNode and n is the value or
+		// the key it wraps.
+		s = fmt.Sprintf("%s[%s]", p.Parent.path(seen), p.keyString())
+	default:
+		s = p.path(seen)
+	}
+end:
+	return s
+}
+
+// keyString returns the Node's value formatted for use as a map key in a path.
+// For a KeyNode it is the value of the key it wraps.
+func (n *Node) keyString() (s string) {
+	if n.Type == KeyNode && len(n.nodes) > 1 {
+		n = n.nodes[1]
+	}
+	switch t := n.Value.(type) {
+	case string:
+		s = strconv.Quote(t)
+	default:
+		s = fmt.Sprintf("%v", t)
+	}
+	return s
+}
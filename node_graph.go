@@ -0,0 +1,428 @@
+package typegen
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	. "github.com/mikeschinkel/go-lib"
+)
+
+// NodeGraphVersion is the version of the JSON schema of NodeGraph. It will be
+// incremented if the schema changes in a way that is not backward compatible.
+const NodeGraphVersion = 1
+
+// NodeGraph is the JSON representation of the Nodes returned by
+// `NodeMarshaler.Marshal()`. The Nodes slice cannot be encoded directly because
+// Node has back-pointers to its Parent and its NodeMarshaler, and because Nodes
+// may be shared by more than one parent. A NodeGraph instead lists every Node
+// once, as a NodeRecord, and references Nodes by their Id.
+//
+// A NodeGraph lets a production service dump a snapshot of a value that can later
+// be turned into Go code offline by loading it and passing it to
+// `NewCodeBuilder()`.
+type NodeGraph struct {
+	// Version is the schema version, see NodeGraphVersion.
+	Version int `json:"version"`
+
+	// Order lists the Ids of the Nodes in the order they appear in the Nodes slice.
+	// An Id of 0 represents a nil element, e.g. the unused zero element.
+	Order []int `json:"order"`
+
+	// Records lists every Node reachable from Order, sorted by Id.
+	Records []*NodeRecord `json:"nodes"`
+}
+
+// NodeRecord is the JSON representation of a single Node in a NodeGraph.
+type NodeRecord struct {
+	Id       int    `json:"id"`
+	Type     string `json:"type"`
+	Typename string `json:"typename"`
+	Name     string `json:"name"`
+	Index    int    `json:"index"`
+
+	// Parent is the Id of the Node's Parent, or 0 if it has none.
+	Parent int `json:"parent,omitempty"`
+
+	// Children lists the Ids of the Node's child Nodes, in order.
+	Children []int `json:"children,omitempty"`
+
+	// Value is the scalar value of the Node formatted as a string, the name of the
+	// func for FuncNodes, the message or format of ErrorNodes, or nil for Nodes
+	// that do not have a scalar value, e.g. containers and nil funcs.
+	Value *string `json:"value,omitempty"`
+
+	// Embedded is true for the FieldNode of an embedded field.
+	Embedded bool `json:"embedded,omitempty"`
+
+	// Nil is true for a nil pointer, map, slice, func or interface.
+	Nil bool `json:"nil,omitempty"`
+
+	// Cap is the capacity of a slice.
+	Cap int `json:"cap,omitempty"`
+
+	// Constant is the name of the constant, or the names of the flag constants
+	// joined by `|`, that the value of a scalar Node equals.
+	Constant string `json:"constant,omitempty"`
+
+	// Backing is the Id of the array Node of a slice that shares its backing array
+	// with other slices, or 0 if it has none, and Offset is the index within it of
+	// the slice's first element.
+	Backing int `json:"backing,omitempty"`
+	Offset  int `json:"offset,omitempty"`
+
+	// Base64 is true when Value is base64 encoded because it contains a string
+	// that is not valid UTF-8 and thus cannot be represented in JSON, or bytes.
+	Base64 bool `json:"base64,omitempty"`
+}
+
+// snapshotValue is assigned as the Value of container Nodes loaded from a
+// NodeGraph, since their original Go value is not available. Each is a unique
+// pointer so that the Nodes are still distinct when `CodeBuilder` keys its maps
+// by `reflect.ValueOf(Node.Value)`.
+type snapshotValue struct {
+	id int
+}
+
+// NewNodeGraph returns a *NodeGraph for the Nodes passed.
+func NewNodeGraph(ns Nodes) *NodeGraph {
+	g := &NodeGraph{
+		Version: NodeGraphVersion,
+		Order:   make([]int, len(ns)),
+		Records: make([]*NodeRecord, 0, len(ns)),
+	}
+	seen := make(map[int]*Node)
+	for i, n := range ns {
+		if n == nil {
+			continue
+		}
+		g.Order[i] = n.Id
+		collectNodes(n, seen)
+	}
+	for _, n := range seen {
+		g.Records = append(g.Records, newNodeRecord(n, seen))
+	}
+	sort.Slice(g.Records, func(i, j int) bool {
+		return g.Records[i].Id < g.Records[j].Id
+	})
+	return g
+}
+
+// collectNodes adds n and all Nodes reachable from its children to seen.
+func collectNodes(n *Node, seen map[int]*Node) {
+	if _, found := seen[n.Id]; found {
+		goto end
+	}
+	seen[n.Id] = n
+	for _, child := range n.nodes {
+		collectNodes(child, seen)
+	}
+	if n.Backing != nil {
+		collectNodes(n.Backing, seen)
+	}
+end:
+}
+
+// newNodeRecord returns a *NodeRecord for a Node.
+func newNodeRecord(n *Node, seen map[int]*Node) *NodeRecord {
+	r := &NodeRecord{
+		Id:       n.Id,
+		Type:     n.Type.String(),
+		Typename: n.Typename,
+		Name:     n.Name,
+		Index:    n.Index,
+		Embedded: n.Embedded,
+		Nil:      n.Nil,
+		Cap:      n.Cap,
+		Constant: n.Constant,
+		Offset:   n.Offset,
+	}
+	if n.Backing != nil {
+		r.Backing = n.Backing.Id
+	}
+	if n.Parent != nil {
+		if _, found := seen[n.Parent.Id]; found {
+			r.Parent = n.Parent.Id
+		}
+	}
+	if len(n.nodes) > 0 {
+		r.Children = make([]int, len(n.nodes))
+		for i, child := range n.nodes {
+			r.Children[i] = child.Id
+		}
+	}
+	r.Value, r.Base64 = encodeNodeValue(n)
+	return r
+}
+
+// encodeNodeValue formats the value of scalar and element Nodes as a string, and
+// the value of FuncNodes as the name of the func since funcs cannot be encoded.
+func encodeNodeValue(n *Node) (s *string, isBase64 bool) {
+	var v string
+
+	if n.Type == FuncNode {
+		v = funcName(n.Value)
+		if v != "" {
+			s = &v
+		}
+		goto end
+	}
+	if !OneOf(n.Type, append(ScalarNodeTypes, ElementNode, ErrorNode)...) {
+		goto end
+	}
+	if n.Type == UnsafePointerNode {
+		// Addresses are meaningless outside the process that marshaled them.
+		goto end
+	}
+	if n.Value == nil {
+		goto end
+	}
+	v, isBase64 = formatValue(reflect.ValueOf(n.Value))
+	s = &v
+end:
+	return s, isBase64
+}
+
+// formatValue formats a scalar reflect.Value as a string, returning true if the
+// string had to be base64 encoded.
+func formatValue(rv reflect.Value) (s string, isBase64 bool) {
+	switch rv.Kind() {
+	case reflect.String:
+		s = rv.String()
+		if !utf8.ValidString(s) {
+			s = base64.StdEncoding.EncodeToString([]byte(s))
+			isBase64 = true
+		}
+	case reflect.Bool:
+		s = strconv.FormatBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s = strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32:
+		s = strconv.FormatFloat(rv.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		s = strconv.FormatFloat(rv.Float(), 'g', -1, 64)
+	case reflect.Slice, reflect.Array:
+		// The value of a BytesNode.
+		s = base64.StdEncoding.EncodeToString(nodeBytes(rv.Interface()))
+		isBase64 = true
+	}
+	return s, isBase64
+}
+
+// Nodes rebuilds the Nodes slice from the NodeGraph so that it can be passed to
+// `NewCodeBuilder()`. Nodes loaded this way have no NodeMarshaler, and the Value
+// of container Nodes is a placeholder since the original Go value is not
+// available.
+func (g *NodeGraph) Nodes() (ns Nodes, err error) {
+	if g.Version > NodeGraphVersion {
+		err = fmt.Errorf("unsupported node graph version %d; expected %d or less", g.Version, NodeGraphVersion)
+		goto end
+	}
+	ns, err = g.nodes()
+end:
+	return ns, err
+}
+
+// nodes does the work for Nodes() once the version has been verified.
+func (g *NodeGraph) nodes() (ns Nodes, err error) {
+	byId := make(map[int]*Node, len(g.Records))
+
+	for _, r := range g.Records {
+		var n *Node
+		n, err = r.node()
+		if err != nil {
+			goto end
+		}
+		byId[n.Id] = n
+	}
+	for _, r := range g.Records {
+		n := byId[r.Id]
+		if r.Parent != 0 {
+			n.Parent = byId[r.Parent]
+		}
+		for _, id := range r.Children {
+			child, found := byId[id]
+			if !found {
+				err = fmt.Errorf("node %d references unknown child node %d", r.Id, id)
+				goto end
+			}
+			n.nodes = append(n.nodes, child)
+		}
+		if r.Backing == 0 {
+			continue
+		}
+		n.Backing = byId[r.Backing]
+		if n.Backing == nil {
+			err = fmt.Errorf("node %d references unknown backing node %d", r.Id, r.Backing)
+			goto end
+		}
+	}
+	for _, n := range byId {
+		resolveSnapshotValue(n, make(map[*Node]struct{}))
+	}
+	ns = make(Nodes, len(g.Order))
+	for i, id := range g.Order {
+		if id == 0 {
+			continue
+		}
+		n, found := byId[id]
+		if !found {
+			err = fmt.Errorf("node order references unknown node %d", id)
+			goto end
+		}
+		ns[i] = n
+	}
+end:
+	return ns, err
+}
+
+// node returns a *Node for the NodeRecord with its scalar Value decoded, but
+// without its Parent or children linked.
+func (r *NodeRecord) node() (n *Node, err error) {
+	nt, ok := ParseNodeType(r.Type)
+	if !ok {
+		err = fmt.Errorf("node %d has unknown type '%s'", r.Id, r.Type)
+		goto end
+	}
+	n = (&Node{
+		Id:       r.Id,
+		Type:     nt,
+		Typename: r.Typename,
+		Name:     r.Name,
+		Index:    r.Index,
+		Embedded: r.Embedded,
+		Nil:      r.Nil,
+		Cap:      r.Cap,
+		Constant: r.Constant,
+		Offset:   r.Offset,
+	}).Reset()
+	if r.Value == nil {
+		goto end
+	}
+	n.Value, err = r.decodeValue(nt)
+end:
+	return n, err
+}
+
+// decodeValue parses the NodeRecord's Value into the Go type for the NodeType.
+func (r *NodeRecord) decodeValue(nt NodeType) (v any, err error) {
+	var i int64
+	var u uint64
+	var f float64
+	var b []byte
+
+	s := *r.Value
+	switch nt {
+	case StringNode, SubstitutionNode, ErrorNode, GlobalNode:
+		v = s
+		if !r.Base64 {
+			goto end
+		}
+		b, err = base64.StdEncoding.DecodeString(s)
+		v = string(b)
+	case FuncNode:
+		v = s
+	case BytesNode:
+		v, err = base64.StdEncoding.DecodeString(s)
+	case BoolNode:
+		v, err = strconv.ParseBool(s)
+	case IntNode, ElementNode:
+		i, err = strconv.ParseInt(s, 10, 0)
+		v = int(i)
+	case Int8Node:
+		i, err = strconv.ParseInt(s, 10, 8)
+		v = int8(i)
+	case Int16Node:
+		i, err = strconv.ParseInt(s, 10, 16)
+		v = int16(i)
+	case Int32Node:
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = int32(i)
+	case Int64Node:
+		i, err = strconv.ParseInt(s, 10, 64)
+		v = i
+	case UintNode:
+		u, err = strconv.ParseUint(s, 10, 0)
+		v = uint(u)
+	case Uint8Node:
+		u, err = strconv.ParseUint(s, 10, 8)
+		v = uint8(u)
+	case Uint16Node:
+		u, err = strconv.ParseUint(s, 10, 16)
+		v = uint16(u)
+	case Uint32Node:
+		u, err = strconv.ParseUint(s, 10, 32)
+		v = uint32(u)
+	case Uint64Node:
+		u, err = strconv.ParseUint(s, 10, 64)
+		v = u
+	case UintptrNode:
+		u, err = strconv.ParseUint(s, 10, 64)
+		v = uintptr(u)
+	case Float32Node:
+		f, err = strconv.ParseFloat(s, 32)
+		v = float32(f)
+	case Float64Node:
+		f, err = strconv.ParseFloat(s, 64)
+		v = f
+	default:
+		err = fmt.Errorf("node %d of type '%s' cannot have a value", r.Id, nt)
+	}
+end:
+	if err != nil {
+		err = fmt.Errorf("node %d has invalid value '%s': %w", r.Id, s, err)
+	}
+	return v, err
+}
+
+// resolveSnapshotValue assigns a Value to container Nodes loaded from a
+// NodeGraph. Interfaces take on the Value of the Node they contain, just as they
+// do when marshaled, and other containers are given a unique snapshotValue.
+func resolveSnapshotValue(n *Node, seen map[*Node]struct{}) (v any) {
+	if _, found := seen[n]; found {
+		goto end
+	}
+	seen[n] = struct{}{}
+	switch n.Type {
+	case InterfaceNode:
+		if len(n.nodes) == 0 {
+			goto end
+		}
+		n.Value = resolveSnapshotValue(n.nodes[0], seen)
+	case PointerNode, MapNode, ArrayNode, SliceNode, StructNode, FuncNode:
+		if n.Value != nil {
+			goto end
+		}
+		n.Value = &snapshotValue{id: n.Id}
+	}
+end:
+	return n.Value
+}
+
+// MarshalJSON encodes the Nodes as a NodeGraph.
+func (ns Nodes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewNodeGraph(ns))
+}
+
+// UnmarshalJSON decodes a NodeGraph and rebuilds the Nodes from it.
+func (ns *Nodes) UnmarshalJSON(data []byte) (err error) {
+	var nodes Nodes
+	g := NodeGraph{}
+	err = json.Unmarshal(data, &g)
+	if err != nil {
+		goto end
+	}
+	nodes, err = g.Nodes()
+	if err != nil {
+		goto end
+	}
+	*ns = nodes
+end:
+	return err
+}
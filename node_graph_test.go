@@ -0,0 +1,28 @@
+package typegen_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mikeschinkel/go-typegen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodes_JSONRoundTrip(t *testing.T) {
+	for _, tt := range marshalTests() {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(testSubstitutions())
+			data, err := json.Marshal(m.Marshal(tt.value))
+			if !assert.NoError(t, err) {
+				return
+			}
+			var nodes typegen.Nodes
+			err = json.Unmarshal(data, &nodes)
+			if !assert.NoError(t, err) {
+				return
+			}
+			b := typegen.NewCodeBuilder("getData", "typegen_test", nodes)
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
@@ -3,10 +3,10 @@ package typegen
 import (
 	"fmt"
 	"reflect"
-	"sort"
 	"strings"
 
 	"github.com/mikeschinkel/go-diffator"
+	. "github.com/mikeschinkel/go-lib"
 )
 
 type Substitutions map[reflect.Type]func(*reflect.Value) string
@@ -20,6 +20,48 @@ type NodeMarshaler struct {
 	debugString   string
 	substitutions Substitutions
 	nextNodeId    int
+
+	// reflectTypes maps the Id of each Node whose NodeType was derived from its
+	// reflect.Value to the value's reflect.Type, for `Node.ReflectType()`.
+	reflectTypes map[int]reflect.Type
+
+	// UnsafePointerTypes maps the paths of `unsafe.Pointer` values, e.g. `.Buf`,
+	// to the types they point to so that they can be followed and their targets
+	// marshaled as if they were pointers to those types. Without a type an
+	// `unsafe.Pointer` is generated per `CodeBuilder.UnsafePolicy`.
+	UnsafePointerTypes map[string]reflect.Type
+
+	// Sentinels maps sentinel errors to the names of the variables that hold them
+	// so that they are generated by name, as are those in StdSentinels. Other
+	// errors whose types are not exported are generated by `errors.New()` or
+	// `fmt.Errorf()`.
+	Sentinels SentinelRegistry
+
+	// UseStringer, when true, takes the names of the constants that values of
+	// defined integer and string types equal from their String() method, as
+	// generated by `stringer`, so they are generated as e.g. `paint.Red` rather
+	// than `paint.Color(0)`. Names are only taken if they are those of constants
+	// declared with the type that equal the value, as found by Types.
+	UseStringer bool
+
+	// Types verifies the names returned by String() when UseStringer is set. It
+	// defaults to a TypeResolver for code outside of any package, so constants
+	// that are not exported are not used; assign one for the package the code
+	// will be used within to use those of that package.
+	Types *TypeResolver
+
+	// byIdentity is set while marshaling a pointer that is a map key, and the
+	// value it points to, so that `NodeMarshaler.isRegistered()` finds pointers
+	// only by address rather than also values by equality.
+	byIdentity bool
+
+	// globals contains the package-level variables registered by
+	// `NodeMarshaler.RegisterGlobal()`, in the order registered.
+	globals []global
+
+	// slices contains the non-nil slices marshaled, for finding those that share a
+	// backing array in `NodeMarshaler.shareBackingArrays()`.
+	slices []sliceRef
 }
 
 func (m *NodeMarshaler) String() string {
@@ -35,6 +77,7 @@ func NewNodeMarshaler(subs Substitutions) *NodeMarshaler {
 	m := &NodeMarshaler{
 		substitutions: subs,
 		nodes:         make(Nodes, 0),
+		reflectTypes:  make(map[int]reflect.Type),
 	}
 	resetDebugString(m)
 
@@ -43,14 +86,19 @@ func NewNodeMarshaler(subs Substitutions) *NodeMarshaler {
 
 func (m *NodeMarshaler) NewNode(args *NodeArgs) (n *Node) {
 	m.nextNodeId++
+	if !OneOf(args.Type, ElementNode, SubstitutionNode) && args.ReflectValue != nil && args.ReflectValue.IsValid() {
+		m.reflectTypes[m.nextNodeId] = args.ReflectValue.Type()
+	}
 	return NewNode(m.nextNodeId, args)
 }
 
 func (m *NodeMarshaler) reinitialize() {
 	m.nodeMap = make(NodeMap)
 	m.ptrMap = make(PointerMap)
+	m.slices = nil
 	// Zero element is unused so node.index==0 can represent invalid
 	m.nodes = make(Nodes, 1)
+	m.addGlobals()
 }
 
 func (m *NodeMarshaler) Nodes() Nodes {
@@ -76,6 +124,7 @@ func (m *NodeMarshaler) Marshal(value any) Nodes {
 		// the one value, so it can be converted in .String().
 		m.registerNode(&rv, m.root)
 	}
+	m.shareBackingArrays()
 
 	// Ensure the root node is not duplicated if referenced elsewhere by making sure
 	// all nodes are connected.
@@ -104,10 +153,18 @@ func (m *NodeMarshaler) marshalValue(rv *reflect.Value, parent *Node) (node *Nod
 			goto end
 		}
 	}
+	node = m.marshalError(rv, parent)
+	if node != nil {
+		goto end
+	}
 	node = m.marshalContainers(rv, parent)
 	if node != nil {
 		goto end
 	}
+	if rv.Kind() == reflect.UnsafePointer {
+		node = m.marshalUnsafePointer(rv, parent)
+		goto end
+	}
 	name = "nil"
 	if rv.IsValid() {
 		name = fmt.Sprintf("%s(%s)",
@@ -122,12 +179,19 @@ func (m *NodeMarshaler) marshalValue(rv *reflect.Value, parent *Node) (node *Nod
 		ReflectValue: rv,
 		Parent:       parent,
 	})
+	if m.UseStringer {
+		node.Constant = m.stringerConstant(rv)
+	}
 end:
 	return node
 }
 
 func (m *NodeMarshaler) marshalContainers(rv *reflect.Value, parent *Node) (node *Node) {
 
+	if rv.IsValid() && isBytes(rv.Type()) {
+		node = m.marshalBytes(rv, parent)
+		goto end
+	}
 	switch rv.Kind() {
 	case reflect.Ptr:
 		node = m.marshalPointer(rv, parent)
@@ -148,6 +212,24 @@ end:
 	return node
 }
 
+// marshalBytes marshals a []byte or byte array value as a single Node rather
+// than as an Element Node per byte, since byte values such as payloads can be
+// very large.
+func (m *NodeMarshaler) marshalBytes(rv *reflect.Value, parent *Node) (node *Node) {
+	node = m.NewNode(&NodeArgs{
+		Name:         rv.Type().String(),
+		Type:         BytesNode,
+		marshaler:    m,
+		ReflectValue: rv,
+		Parent:       parent,
+	})
+	if rv.Kind() == reflect.Slice {
+		node.Cap = rv.Cap()
+		m.addSlice(rv, node)
+	}
+	return node
+}
+
 // marshalArray marshals an array value to create a Node
 func (m *NodeMarshaler) marshalArray(rv *reflect.Value, parent *Node) (node *Node) {
 	return m.marshalElements(rv, parent, func() string {
@@ -165,8 +247,23 @@ func (m *NodeMarshaler) marshalSlice(rv *reflect.Value, parent *Node) (node *Nod
 // marshalElements marshals both array and slice values to create Nodes
 func (m *NodeMarshaler) marshalElements(rv *reflect.Value, parent *Node, nameFunc func() string) (node *Node) {
 	var index reflect.Value
+	var found bool
 
-	node, found := m.isRegistered(rv)
+	if rv.Kind() == reflect.Slice && rv.IsNil() {
+		// Like a nil pointer, a nil slice has nothing to register.
+		node = m.NewNode(&NodeArgs{
+			Name:         nameFunc() + " (nil)",
+			marshaler:    m,
+			ReflectValue: rv,
+			Parent:       parent,
+		})
+		goto end
+	}
+	if rv.Kind() == reflect.Slice {
+		node, found = m.findSlice(rv)
+	} else {
+		node, found = m.isRegistered(rv)
+	}
 	if found {
 		goto end
 	}
@@ -178,6 +275,10 @@ func (m *NodeMarshaler) marshalElements(rv *reflect.Value, parent *Node, nameFun
 	})
 	m.registerNode(rv, node)
 
+	if rv.Kind() == reflect.Slice {
+		node.Cap = rv.Cap()
+		m.addSlice(rv, node)
+	}
 	node.SetNodeCount(rv.Len())
 	for i := 0; i < rv.Len(); i++ {
 		reflectValue := reflect.ValueOf(i)
@@ -202,15 +303,23 @@ end:
 
 func (m *NodeMarshaler) marshalMap(rv *reflect.Value, parent *Node) (node *Node) {
 	var name string
-	var index reflect.Value
+	var keys, values []reflect.Value
+	var found bool
 
-	var keys []reflect.Value
-
-	node, found := m.isRegistered(rv)
+	name = fmt.Sprintf("map[%s]%s", rv.Type().Key(), rv.Type().Elem())
+	if rv.IsNil() {
+		node = m.NewNode(&NodeArgs{
+			Name:         name + " (nil)",
+			marshaler:    m,
+			ReflectValue: rv,
+			Parent:       parent,
+		})
+		goto end
+	}
+	node, found = m.isRegistered(rv)
 	if found {
 		goto end
 	}
-	name = fmt.Sprintf("map[%s]%s", rv.Type().Key(), rv.Type().Elem())
 	node = m.NewNode(&NodeArgs{
 		Name:         name,
 		marshaler:    m,
@@ -218,13 +327,33 @@ func (m *NodeMarshaler) marshalMap(rv *reflect.Value, parent *Node) (node *Node)
 		Parent:       parent,
 	})
 	m.registerNode(rv, node)
-	keys = m.sortedKeys(rv)
+	keys, values = m.sortedEntries(rv)
 	node.SetNodeCount(len(keys))
-	for _, key := range keys {
+	for i, key := range keys {
 		child := m.marshalValue(&key, node)
-		node.AddNode(child)
-		index = rv.MapIndex(key)
-		child.AddNode(m.marshalValue(&index, child))
+		entry := child
+		if !OneOf(child.Type, ScalarNodeTypes...) {
+			// The sole child of a scalar key is its value, so a key that can have
+			// children of its own, e.g. a struct, is wrapped in a KeyNode whose first
+			// child is the value and whose second child is the key.
+			entry = m.NewNode(&NodeArgs{
+				Name:      "key",
+				Type:      KeyNode,
+				marshaler: m,
+				Typename:  "key",
+			})
+		}
+		node.AddNode(entry)
+		entry.AddNode(m.marshalValue(&values[i], entry))
+		switch {
+		case entry == child:
+		case child.Parent == node:
+			entry.AddNode(child)
+		default:
+			// The key was marshaled before, e.g. a pointer also held by a slice, so
+			// keep the parent it was first reached from.
+			entry.nodes = entry.nodes.AppendNode(child)
+		}
 	}
 end:
 	return node
@@ -234,7 +363,17 @@ func (m *NodeMarshaler) marshalPointer(rv *reflect.Value, parent *Node) (node *N
 	var name string
 	var elem reflect.Value
 
+	byIdentity := m.byIdentity
+	if parent != nil && parent.Type == MapNode {
+		// Distinct pointers are distinct keys even if the values they point to are
+		// equal, so only the very same pointer is the same key.
+		m.byIdentity = true
+	}
 	node, found := m.isRegistered(rv)
+	if found && parent == nil && isGlobalRef(node) {
+		// The root is what was asked for so it is marshaled as a copy of the global.
+		found = false
+	}
 	if found {
 		goto end
 	}
@@ -258,14 +397,24 @@ func (m *NodeMarshaler) marshalPointer(rv *reflect.Value, parent *Node) (node *N
 	elem = rv.Elem()
 	node.AddNode(m.marshalValue(&elem, node))
 end:
+	m.byIdentity = byIdentity
 	return node
 }
 
 func (m *NodeMarshaler) marshalInterface(rv *reflect.Value, parent *Node) (node *Node) {
 	var name string
 	var elem reflect.Value
-
-	node, found := m.isRegistered(rv)
+	var found bool
+
+	switch {
+	case isErrorValue(rv):
+		// Errors are compared by identity, e.g. by `errors.Is()`, so errors that are
+		// merely equal are not the same error.
+	case m.holdsGlobalPointer(rv):
+		// A pointer to a global must not be mistaken for a pointer to an equal value.
+	default:
+		node, found = m.isRegistered(rv)
+	}
 	if found {
 		goto end
 	}
@@ -318,6 +467,7 @@ func (m *NodeMarshaler) marshalStruct(rv *reflect.Value, parent *Node) (node *No
 			marshaler: m,
 			Index:     i,
 			Typename:  "field", // TODO Decide something better, maybe?
+			Embedded:  rt.Field(i).Anonymous,
 		})
 		node.AddNode(child)
 		crv := rv.Field(i)
@@ -338,13 +488,21 @@ end:
 // Called when marshalling collection types; array, slice, map, pointer,
 // interface, and struct.
 func (m *NodeMarshaler) registerNode(rv *reflect.Value, n *Node) {
-	_, found := m.isRegistered(rv)
-	if found {
+	var registered *Node
+	var found bool
+
+	if rv.Kind() != reflect.Slice {
+		// Slices that are equal are not the same slice; see `NodeMarshaler.findSlice()`.
+		registered, found = m.isRegistered(rv)
+	}
+	if found && !isGlobalRef(registered) {
 		goto end
 	}
 	m.nodeMap[*rv] = n
 	resetDebugString(n)
-	if rv.Kind() == reflect.Pointer {
+	if rv.Kind() == reflect.Pointer && !isGlobalRef(m.ptrMap[rv.Pointer()]) {
+		// A pointer to the first field or element of a global has the same address
+		// as the global, which must still be found.
 		m.ptrMap[rv.Pointer()] = n
 	}
 	m.nodes = append(m.nodes, n)
@@ -356,17 +514,27 @@ end:
 func (m *NodeMarshaler) isRegistered(rv *reflect.Value) (node *Node, found bool) {
 
 	if rv.Kind() != reflect.Pointer {
-		node, found = m.findNodeMapKey(rv)
+		if !m.byIdentity {
+			node, found = m.findNodeMapKey(rv)
+		}
 		goto end
 	}
 
 	node, found = m.ptrMap[rv.Pointer()]
+	if found && isGlobalRef(node) && node.ReflectType() != rv.Type() {
+		// A pointer to the first field of a global has the address of the global.
+		found = false
+	}
 	if found {
 		// If the value of `rv` is a pointer, and we previously recorded it, then skip
 		// registration.
 		goto end
 	}
 
+	if m.byIdentity {
+		goto end
+	}
+
 	// Look for the value pointed to having already been registered
 	node, found = m.findNodeMapKey(rv)
 
@@ -417,15 +585,3 @@ end:
 	}
 	return node, found
 }
-
-func (m *NodeMarshaler) sortedKeys(rv *reflect.Value) (keys []reflect.Value) {
-	keyValues := rv.MapKeys()
-	keys = make([]reflect.Value, len(keyValues))
-	for i, k := range keyValues {
-		keys[i] = k
-	}
-	sort.SliceStable(keys, func(i, j int) bool {
-		return keys[i].String() < keys[j].String()
-	})
-	return keys
-}
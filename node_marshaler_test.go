@@ -1,7 +1,10 @@
 package typegen_test
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"testing"
 
@@ -28,7 +31,207 @@ type testData struct {
 }
 
 func TestNodeBuilder_Marshal(t *testing.T) {
-	tests := []testData{
+	tests := marshalTests()
+	subs := testSubstitutions()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(subs)
+			nodes := m.Marshal(tt.value)
+			if !tt.skipNodes {
+				want := tt.nodes(m)
+				got := nodes
+				diff := getDiff(want, got)
+				if diff != "" {
+					t.Errorf(diff)
+				}
+				//assert.Equal(t, want, got)
+			}
+			b := typegen.NewCodeBuilder("getData", "typegen_test", nodes)
+			got := b.String()
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+type keyPoint struct {
+	X, Y int
+}
+
+type keyItem struct {
+	Qty int
+}
+
+func TestNodeMarshaler_MapKeyOrder(t *testing.T) {
+	second, first := &keyItem{Qty: 2}, &keyItem{Qty: 1}
+	tests := []struct {
+		name  string
+		value any
+		want  []any
+	}{
+		{
+			name:  "Struct keys field by field",
+			value: map[keyPoint]int{{X: 2, Y: 1}: 1, {X: 1, Y: 2}: 2, {X: 1, Y: 1}: 3},
+			want:  []any{keyPoint{X: 1, Y: 1}, keyPoint{X: 1, Y: 2}, keyPoint{X: 2, Y: 1}},
+		},
+		{
+			name:  "Unvisited pointer keys by value",
+			value: map[*keyItem]int{second: 2, first: 1},
+			want:  []any{first, second},
+		},
+		{
+			name: "Visited pointer keys by first visit",
+			value: &struct {
+				Items []*keyItem
+				Index map[*keyItem]int
+			}{
+				Items: []*keyItem{second, first},
+				Index: map[*keyItem]int{first: 1, second: 0},
+			},
+			want: []any{second, first},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mapNode *Node
+
+			m := typegen.NewNodeMarshaler(nil)
+			for _, n := range m.Marshal(tt.value) {
+				if n != nil && n.Type == typegen.MapNode {
+					mapNode = n
+				}
+			}
+			if !assert.NotNil(t, mapNode) {
+				return
+			}
+			got := make([]any, len(mapNode.Nodes()))
+			for i, key := range mapNode.Nodes() {
+				if key.Type == typegen.KeyNode {
+					key = key.Nodes()[1]
+				}
+				got[i] = key.Value
+			}
+			for i := range tt.want {
+				assert.True(t, tt.want[i] == got[i], "key %d: want %v, got %v", i, tt.want[i], got[i])
+			}
+		})
+	}
+}
+
+func TestNodeMarshaler_MapKeyOrderIsStable(t *testing.T) {
+	value := make(map[int]string)
+	for i := 0; i < 100; i++ {
+		value[i*7%100] = fmt.Sprint(i)
+	}
+	var want string
+	for i := 0; i < 10; i++ {
+		m := typegen.NewNodeMarshaler(nil)
+		got := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(value)).String()
+		if i == 0 {
+			want = got
+		}
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestNodeMarshaler_EqualMapKeyOrderIsStable(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+	}{
+		{
+			name:  "Pointers to equal values",
+			value: map[*keyItem]int{{Qty: 1}: 1, {Qty: 1}: 2, {Qty: 1}: 3},
+		},
+		{
+			name:  "NaNs",
+			value: map[float64]int{math.NaN(): 1, math.NaN(): 2, math.NaN(): 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want string
+			for i := 0; i < 50; i++ {
+				m := typegen.NewNodeMarshaler(nil)
+				got := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value)).String()
+				if i == 0 {
+					want = got
+				}
+				assert.Equal(t, want, got)
+			}
+		})
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+func TestNodeMarshaler_Sentinels(t *testing.T) {
+	value := []error{errNotFound, errors.New("not found"), fmt.Errorf("get: %w", errNotFound)}
+	m := typegen.NewNodeMarshaler(nil)
+	m.Sentinels = typegen.SentinelRegistry{
+		errNotFound: "github.com/mikeschinkel/go-typegen_test.errNotFound",
+	}
+	got := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(value)).String()
+	want := wantValue(`[]error`, `[]error{errNotFound, errors.New("not found"), fmt.Errorf("get: %w", errNotFound)}`)
+	assert.Equal(t, want, got)
+}
+
+type options struct {
+	Name    string
+	Retries int
+}
+
+var defaultOptions = options{Name: "default", Retries: 3}
+
+type optionsHolder struct {
+	Opts  *options
+	Copy  *options
+	Name  *string
+	Any   any
+	Err   error
+	Index map[*options]int
+}
+
+func TestNodeMarshaler_RegisterGlobal(t *testing.T) {
+	copied := defaultOptions
+	value := &optionsHolder{
+		Opts:  &defaultOptions,
+		Copy:  &copied,
+		Name:  &defaultOptions.Name,
+		Any:   &defaultOptions,
+		Err:   errNotFound,
+		Index: map[*options]int{&defaultOptions: 1},
+	}
+	want := wantPtrValue(`optionsHolder`,
+		"optionsHolder{Opts: &defaultOptions, Copy: nil, Name: nil, Any: &defaultOptions, Err: errNotFound, Index: nil}\n"+
+			"  var2 := options{Name: \"default\", Retries: 3}\n"+
+			"  var3 := \"default\"\n"+
+			"  var4 := map[*options]int{&defaultOptions: 1}\n"+
+			"  var1.Copy = &var2\n"+
+			"  var1.Name = &var3\n"+
+			"  var1.Index = var4",
+	)
+	m := typegen.NewNodeMarshaler(nil)
+	m.RegisterGlobal("github.com/mikeschinkel/go-typegen_test.defaultOptions", &defaultOptions)
+	m.RegisterGlobal("github.com/mikeschinkel/go-typegen_test.errNotFound", &errNotFound)
+	nodes := m.Marshal(value)
+	assert.Equal(t, want, typegen.NewCodeBuilder("getData", "typegen_test", nodes).String())
+
+	data, err := json.Marshal(nodes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	nodes = nil
+	err = json.Unmarshal(data, &nodes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, want, typegen.NewCodeBuilder("getData", "typegen_test", nodes).String())
+}
+
+// marshalTests returns the test cases for TestNodeBuilder_Marshal which are also
+// used to test other features against a wide variety of values.
+func marshalTests() []testData {
+	return []testData{
 		int100Node(),
 		int64Node(),
 		boolNode(),
@@ -51,30 +254,56 @@ func TestNodeBuilder_Marshal(t *testing.T) {
 		anySliceOfReflectValueOf10(),
 		pointerToStructWithPropertyPointingToItself(),
 		pointerToStructWithIndirectPropertyPointingToItself(),
+		anonymousStructWithTags(),
+		sliceOfAnonymousStructs(),
+		mapOfAnonymousStructs(),
+		embeddedStructs(),
+		embeddedPointer(),
+		nilEmbeddedPointer(),
+		embeddedInterface(),
+		nilEmbeddedInterface(),
+		printableBytes(),
+		binaryBytes(),
+		byteArray(),
+		structWithBytesField(),
+		namedString(),
+		sliceOfNamedStringsInInterfaces(),
+		intKeyedMap(),
+		floatKeyedMap(),
+		floatValues(),
+		structKeyedMap(),
+		arrayKeyedMap(),
+		pointerKeyedMap(),
+		equalPointerKeyedMap(),
+		interfaceKeyedMap(),
+		registryMap(),
+		selfReferencingMap(),
+		mapOfInterfacesWithReferences(),
+		sliceOfStructPointers(),
+		funcFields(),
+		funcsInInterfaces(),
+		unsafeFields(),
+		pointerToScalarBeforeSlice(),
+		nilAndEmptyFields(),
+		typedNilsInInterfaces(),
+		nilRootPointer(),
+		sharedBackingArrays(),
+		equalSlicesNotShared(),
+		interfacesWithDeclaredTypes(),
+		containersInInterfaces(),
+		pointerToInterface(),
+		structWithReferencesInInterface(),
+		errorValues(),
+		durations(),
 	}
-	subs := typegen.Substitutions{
+}
+
+func testSubstitutions() typegen.Substitutions {
+	return typegen.Substitutions{
 		reflect.TypeOf(reflect.Value{}): func(rv *reflect.Value) string {
 			return fmt.Sprintf("reflect.ValueOf(%v)", (*rv).Interface())
 		},
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			m := typegen.NewNodeMarshaler(subs)
-			nodes := m.Marshal(tt.value)
-			if !tt.skipNodes {
-				want := tt.nodes(m)
-				got := nodes
-				diff := getDiff(want, got)
-				if diff != "" {
-					t.Errorf(diff)
-				}
-				//assert.Equal(t, want, got)
-			}
-			b := typegen.NewCodeBuilder("getData", "typegen_test", nodes)
-			got := b.String()
-			assert.Equal(t, tt.want, got)
-		})
-	}
 }
 
 func getDiff(want, got any) (diff string) {
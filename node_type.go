@@ -36,11 +36,15 @@ const (
 	FieldNode         = NodeType(reflect.UnsafePointer + 10)
 	ElementNode       = NodeType(reflect.UnsafePointer + 11)
 	SubstitutionNode  = NodeType(reflect.UnsafePointer + 12)
+	BytesNode         = NodeType(reflect.UnsafePointer + 13)
+	KeyNode           = NodeType(reflect.UnsafePointer + 14)
+	ErrorNode         = NodeType(reflect.UnsafePointer + 15)
+	GlobalNode        = NodeType(reflect.UnsafePointer + 16)
 )
 
 var (
 	// ScalarNodeTypes groups NodeTypes that represent scalar values into a slice for
-	// convenient use with isOneOf() which is called in .scalarChildWritten().
+	// convenient use with isOneOf() which is called in .scalarChildExpr().
 	ScalarNodeTypes = []NodeType{
 		StringNode,
 		IntNode,
@@ -59,6 +63,8 @@ var (
 		BoolNode,
 		UnsafePointerNode,
 		SubstitutionNode,
+		BytesNode,
+		GlobalNode,
 	}
 )
 
@@ -122,8 +128,66 @@ func nodeTypeName(nt NodeType) (s string) {
 		s = "uintptr"
 	case SubstitutionNode:
 		s = "substitution"
+	case BytesNode:
+		s = "bytes"
+	case KeyNode:
+		s = "key"
+	case ErrorNode:
+		s = "error"
+	case GlobalNode:
+		s = "global"
 	default:
 		Panicf("Invalid node type: %d", nt)
 	}
 	return s
 }
+
+// nodeTypes lists every NodeType so that ParseNodeType() can find a NodeType by
+// its name.
+var nodeTypes = []NodeType{
+	PointerNode,
+	MapNode,
+	ArrayNode,
+	SliceNode,
+	StructNode,
+	InterfaceNode,
+	StringNode,
+	IntNode,
+	Int8Node,
+	Int16Node,
+	Int32Node,
+	Int64Node,
+	UintptrNode,
+	UintNode,
+	Uint8Node,
+	Uint16Node,
+	Uint32Node,
+	Uint64Node,
+	Float32Node,
+	Float64Node,
+	BoolNode,
+	FuncNode,
+	InvalidNode,
+	UnsafePointerNode,
+	FieldNode,
+	ElementNode,
+	SubstitutionNode,
+	BytesNode,
+	KeyNode,
+	ErrorNode,
+	GlobalNode,
+}
+
+// ParseNodeType returns the NodeType whose String() matches name, and false if
+// there is no such NodeType.
+func ParseNodeType(name string) (nt NodeType, ok bool) {
+	for _, t := range nodeTypes {
+		if t.String() != name {
+			continue
+		}
+		nt = t
+		ok = true
+		break
+	}
+	return nt, ok
+}
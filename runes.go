@@ -0,0 +1,47 @@
+package typegen
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+// isRuneType returns true if t was declared as `rune`, or as a type whose
+// underlying type is `rune`. go/types, unlike reflect, retains the name `rune`
+// for the alias of `int32`.
+func isRuneType(t types.Type) (is bool) {
+	var basic *types.Basic
+
+	if t == nil {
+		goto end
+	}
+	basic, is = t.Underlying().(*types.Basic)
+	if !is {
+		goto end
+	}
+	is = basic.Name() == "rune"
+end:
+	return is
+}
+
+// isRunesType returns true if t is a slice of runes, e.g. `[]rune`.
+func isRunesType(t types.Type) (is bool) {
+	var slice *types.Slice
+
+	if t == nil {
+		goto end
+	}
+	slice, is = t.Underlying().(*types.Slice)
+	if !is {
+		goto end
+	}
+	is = isRuneType(slice.Elem())
+end:
+	return is
+}
+
+// runeLit returns a character literal for r, e.g. `'A'` or `'\n'`.
+func runeLit(r rune) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.CHAR, Value: strconv.QuoteRune(r)}
+}
@@ -0,0 +1,67 @@
+package typegen
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// StringFormat determines how `CodeBuilder` generates string literals.
+type StringFormat int
+
+const (
+	// QuotedStrings generates interpreted string literals with escapes, e.g.
+	// `"SELECT *\nFROM orders\n"`.
+	QuotedStrings StringFormat = iota
+
+	// RawStrings generates multi-line strings that contain no backticks as raw
+	// string literals which keep their line breaks. Other strings are quoted.
+	RawStrings
+)
+
+// canBeRaw returns true if s can be generated as a raw string literal without
+// losing or obscuring any of its characters. Raw string literals cannot contain
+// backticks, and carriage returns are discarded from them by the compiler.
+func canBeRaw(s string) (can bool) {
+	if strings.ContainsAny(s, "`\r") {
+		goto end
+	}
+	can = isPrintableText([]byte(s))
+end:
+	return can
+}
+
+// splitLines splits s after each newline into chunks no longer than max, where
+// possible, without splitting any line. A line longer than max is a chunk by
+// itself.
+func splitLines(s string, max int) (chunks []string) {
+	var chunk string
+
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if len(chunk) > 0 && len(chunk)+len(line) > max {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+		chunk += line
+	}
+	if len(chunk) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// rawStringLit returns a raw *ast.BasicLit for s, e.g. "`Hello`".
+func rawStringLit(s string) *ast.BasicLit {
+	return &ast.BasicLit{Kind: token.STRING, Value: "`" + s + "`"}
+}
+
+// stringLitFor returns a raw or quoted *ast.BasicLit for s.
+func stringLitFor(s string, raw bool) (lit *ast.BasicLit) {
+	if raw {
+		lit = rawStringLit(s)
+		goto end
+	}
+	lit = stringLit(s)
+end:
+	return lit
+}
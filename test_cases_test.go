@@ -1,7 +1,15 @@
 package typegen_test
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
 	"reflect"
+	"strings"
+	"time"
+	"unsafe"
 
 	"github.com/mikeschinkel/go-typegen"
 )
@@ -91,7 +99,7 @@ func float64Node() testData {
 	return testData{
 		name:  "Float",
 		value: 1.23,
-		want:  wantValue("float64", `float64(1.230000)`),
+		want:  wantValue("float64", `1.23`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -136,7 +144,7 @@ func pointerToSimpleStructNode() testData {
 	return testData{
 		name:  "Pointer to simple struct",
 		value: &myStruct,
-		want:  wantPtrValue(`testStruct`, `testStruct{Int:0,String:"",}`),
+		want:  wantPtrValue(`testStruct`, `testStruct{Int: 0, String: ""}`),
 		nodes: func(m *nM) Nodes {
 			return FixupNodes(Nodes{
 				nil,
@@ -239,7 +247,7 @@ func pointerToInterfaceStructContainingInterfacesNode() testData {
 	return testData{
 		name:  "Pointer to interface struct containing interface{}(string) and any(int)",
 		value: &iFace,
-		want:  wantPtrValue(`IFaceStruct`, `IFaceStruct{iFace1:"Hello",iFace2:10,}`),
+		want:  wantPtrValue(`IFaceStruct`, `IFaceStruct{iFace1: "Hello", iFace2: 10}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -337,7 +345,7 @@ func simpleStringIntMapNode() testData {
 		name:  "Simple string/int map",
 		value: intMap,
 		// Keys will be sorted alphabetically on output
-		want:      wantValue("map[string]int", `map[string]int{"Bar":2,"Baz":3,"Foo":1,}`),
+		want:      wantValue("map[string]int", `map[string]int{"Bar": 2, "Baz": 3, "Foo": 1}`),
 		skipNodes: false,
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
@@ -422,7 +430,7 @@ func pointerToSimpleStruct() testData {
 	return testData{
 		name:  "Pointer to simple struct",
 		value: value,
-		want:  wantPtrValue(`testStruct`, `testStruct{Int:0,String:"",}`),
+		want:  wantPtrValue(`testStruct`, `testStruct{Int: 0, String: ""}`),
 		nodes: func(m *nM) Nodes {
 			return FixupNodes(Nodes{
 				nil,
@@ -495,7 +503,7 @@ func sliceOfAnyContainingHelloGoodbye() testData {
 	return testData{
 		name:  "Slice of any containing \"Hello\", \"GoodBye\"",
 		value: value,
-		want:  wantValue(`[]any`, `[]any{"Hello","Goodbye",}`),
+		want:  wantValue(`[]any`, `[]any{"Hello", "Goodbye"}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -504,6 +512,7 @@ func sliceOfAnyContainingHelloGoodbye() testData {
 					Id:        1,
 					Index:     0,
 					Type:      typegen.SliceNode,
+					Cap:       2,
 					Value:     value,
 					Name:      "[]interface {}",
 					Typename:  "[]interface {}",
@@ -584,7 +593,7 @@ func simpleAnySliceAllSameNumbers() testData {
 	return testData{
 		name:  "Simple any slice, all same numbers",
 		value: value,
-		want:  wantValue(`[]any`, `[]any{1,1,1,}`),
+		want:  wantValue(`[]any`, `[]any{1, 1, 1}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -592,6 +601,7 @@ func simpleAnySliceAllSameNumbers() testData {
 					Marshaler: m,
 					Id:        1,
 					Type:      typegen.SliceNode,
+					Cap:       3,
 					Name:      "[]interface {}",
 					Typename:  "[]interface {}",
 					Value:     value,
@@ -701,7 +711,7 @@ func simpleAnySlice123() testData {
 	return testData{
 		name:  "Slice of `any` containing 1,2,3",
 		value: value,
-		want:  wantValue(`[]any`, `[]any{1,2,3,}`),
+		want:  wantValue(`[]any`, `[]any{1, 2, 3}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -709,6 +719,7 @@ func simpleAnySlice123() testData {
 					Marshaler: m,
 					Id:        1,
 					Type:      typegen.SliceNode,
+					Cap:       3,
 					Name:      "[]interface {}",
 					Typename:  "[]interface {}",
 					Value:     value,
@@ -818,7 +829,7 @@ func simple3ElementIntArray123() testData {
 	return testData{
 		name:  "Simple 3-element int array: 1, 2, 3",
 		value: value,
-		want:  wantValue(`[3]int`, `[3]int{1,2,3,}`),
+		want:  wantValue(`[3]int`, `[3]int{1, 2, 3}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -922,7 +933,7 @@ func simple3ElementIntSlice123() testData {
 	return testData{
 		name:  "Simple 3-element int slice: 1, 2, 3",
 		value: value,
-		want:  wantValue(`[]int`, `[]int{1,2,3,}`),
+		want:  wantValue(`[]int`, `[]int{1, 2, 3}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -930,6 +941,7 @@ func simple3ElementIntSlice123() testData {
 					Marshaler: m,
 					Id:        1,
 					Type:      typegen.SliceNode,
+					Cap:       3,
 					Name:      "[]int",
 					Typename:  "[]int",
 					Value:     value,
@@ -1047,7 +1059,7 @@ func anySliceOfReflectValueOf10() testData {
 	return testData{
 		name:  "[]any{reflect.ValueOf(10)}",
 		value: value,
-		want:  wantValue(`[]any`, `[]any{reflect.ValueOf(10),}`),
+		want:  wantValue(`[]any`, `[]any{reflect.ValueOf(10)}`),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -1055,6 +1067,7 @@ func anySliceOfReflectValueOf10() testData {
 					Marshaler: m,
 					Id:        1,
 					Type:      typegen.SliceNode,
+					Cap:       1,
 					Name:      "[]interface {}",
 					Typename:  "[]interface {}",
 					Value:     value,
@@ -1107,7 +1120,7 @@ func pointerToStructWithPropertyPointingToItself() testData {
 	return testData{
 		name:  "Pointer to struct with property pointing to itself",
 		value: &recur,
-		want:  wantPtrValue(`recurStruct`, `recurStruct{name:"root",recur:nil,extra:"whatever",}%s  var1.recur = &var1`, "\n"),
+		want:  wantPtrValue(`recurStruct`, `recurStruct{name: "root", recur: nil, extra: "whatever"}%s  var1.recur = &var1`, "\n"),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -1205,7 +1218,7 @@ func pointerToStructWithIndirectPropertyPointingToItself() testData {
 	return testData{
 		name:  "Pointer to struct with indirect property pointing to itself",
 		value: &recur,
-		want:  wantPtrValue(`recurStruct`, `recurStruct{recur:nil,}%s  var2 := []*recurStruct{nil,}%s  var1.recur = var2%s  var2[0] = &var1`, "\n", "\n", "\n"),
+		want:  wantPtrValue(`recurStruct`, `recurStruct{recur: nil}%s  var2 := []*recurStruct{nil}%s  var1.recur = var2%s  var2[0] = &var1`, "\n", "\n", "\n"),
 		nodes: func(m *nM) typegen.Nodes {
 			return FixupNodes(typegen.Nodes{
 				nil,
@@ -1231,6 +1244,7 @@ func pointerToStructWithIndirectPropertyPointingToItself() testData {
 					Name:      "[]*typegen_test.recurStruct",
 					Typename:  "[]*typegen_test.recurStruct",
 					Type:      typegen.SliceNode,
+					Cap:       1,
 					Value:     []any{"<example>"},
 				},
 			}, func(nodes typegen.Nodes) {
@@ -1268,3 +1282,657 @@ func pointerToStructWithIndirectPropertyPointingToItself() testData {
 		},
 	}
 }
+
+func anonymousStructWithTags() testData {
+	return testData{
+		name: "Pointer to anonymous struct with tags",
+		value: &struct {
+			ID   int `json:"id"`
+			Name string
+		}{ID: 1, Name: "x"},
+		skipNodes: true,
+		want: wantPtrValue("struct {\n  ID   int `json:\"id\"`\n  Name string\n}",
+			"struct {\n    ID   int `json:\"id\"`\n    Name string\n  }{ID: 1, Name: \"x\"}",
+		),
+	}
+}
+
+func sliceOfAnonymousStructs() testData {
+	return testData{
+		name: "Slice of anonymous structs",
+		value: []struct {
+			ID int `json:"id"`
+		}{{ID: 1}, {ID: 2}},
+		skipNodes: true,
+		want: wantValue("[]struct {\n  ID int `json:\"id\"`\n}",
+			"[]struct {\n    ID int `json:\"id\"`\n  }{{ID: 1}, {ID: 2}}",
+		),
+	}
+}
+
+func mapOfAnonymousStructs() testData {
+	return testData{
+		name:      "Map of anonymous structs",
+		value:     map[string]struct{ X, Y int }{"a": {X: 1, Y: 2}},
+		skipNodes: true,
+		want: wantValue("map[string]struct {\n  X int\n  Y int\n}",
+			"map[string]struct {\n    X int\n    Y int\n  }{\"a\": {X: 1, Y: 2}}",
+		),
+	}
+}
+
+type EmbeddedBase struct {
+	ID int
+}
+
+type embeddedBase struct {
+	name string
+}
+
+type Shape interface {
+	Area() int
+}
+
+type square struct {
+	Side int
+}
+
+func (s square) Area() int { return s.Side * s.Side }
+
+type embeddingStruct struct {
+	EmbeddedBase
+	embeddedBase
+}
+
+type embeddingPtrStruct struct {
+	*EmbeddedBase
+	Count int
+}
+
+type embeddingIFaceStruct struct {
+	Shape
+}
+
+func embeddedStructs() testData {
+	return testData{
+		name:      "Pointer to struct with exported and unexported embedded structs",
+		value:     &embeddingStruct{EmbeddedBase: EmbeddedBase{ID: 1}, embeddedBase: embeddedBase{name: "a"}},
+		skipNodes: true,
+		want: wantPtrValue(`embeddingStruct`,
+			`embeddingStruct{EmbeddedBase: EmbeddedBase{ID: 1}, embeddedBase: embeddedBase{name: "a"}}`,
+		),
+	}
+}
+
+func embeddedPointer() testData {
+	return testData{
+		name:      "Pointer to struct with embedded pointer",
+		value:     &embeddingPtrStruct{EmbeddedBase: &EmbeddedBase{ID: 2}, Count: 3},
+		skipNodes: true,
+		want: wantPtrValue(`embeddingPtrStruct`,
+			"embeddingPtrStruct{EmbeddedBase: nil, Count: 3}\n"+
+				"  var2 := EmbeddedBase{ID: 2}\n"+
+				"  var1.EmbeddedBase = &var2",
+		),
+	}
+}
+
+func nilEmbeddedPointer() testData {
+	return testData{
+		name:      "Pointer to struct with nil embedded pointer",
+		value:     &embeddingPtrStruct{Count: 3},
+		skipNodes: true,
+		want:      wantPtrValue(`embeddingPtrStruct`, `embeddingPtrStruct{EmbeddedBase: nil, Count: 3}`),
+	}
+}
+
+func embeddedInterface() testData {
+	return testData{
+		name:      "Pointer to struct with embedded interface",
+		value:     &embeddingIFaceStruct{Shape: square{Side: 2}},
+		skipNodes: true,
+		want:      wantPtrValue(`embeddingIFaceStruct`, `embeddingIFaceStruct{Shape: square{Side: 2}}`),
+	}
+}
+
+func nilEmbeddedInterface() testData {
+	return testData{
+		name:      "Pointer to struct with nil embedded interface",
+		value:     &embeddingIFaceStruct{},
+		skipNodes: true,
+		want:      wantPtrValue(`embeddingIFaceStruct`, `embeddingIFaceStruct{Shape: nil}`),
+	}
+}
+
+func printableBytes() testData {
+	return testData{
+		name:      "Printable byte slice",
+		value:     []byte("Hello, World!\n"),
+		skipNodes: true,
+		want:      wantValue(`[]byte`, `[]byte("Hello, World!\n")`),
+	}
+}
+
+func binaryBytes() testData {
+	return testData{
+		name:      "Binary byte slice",
+		value:     []byte{0x00, 0x01, 0xff},
+		skipNodes: true,
+		want:      wantValue(`[]byte`, `[]byte("\x00\x01\xff")`),
+	}
+}
+
+func byteArray() testData {
+	return testData{
+		name:      "Byte array",
+		value:     [4]byte{'a', 'b', 'c', 'd'},
+		skipNodes: true,
+		want:      wantValue(`[4]byte`, `[4]byte([]byte("abcd"))`),
+	}
+}
+
+func structWithBytesField() testData {
+	return testData{
+		name:      "Pointer to struct with byte slice field",
+		value:     &struct{ Data []byte }{Data: []byte("payload")},
+		skipNodes: true,
+		want: wantPtrValue("struct{ Data []uint8 }",
+			`struct{ Data []uint8 }{Data: []byte("payload")}`,
+		),
+	}
+}
+
+type color string
+
+func namedString() testData {
+	return testData{
+		name:      "Named string type",
+		value:     color("red"),
+		skipNodes: true,
+		want:      wantValue(`color`, `color("red")`),
+	}
+}
+
+func sliceOfNamedStringsInInterfaces() testData {
+	return testData{
+		name:      "Slice of interfaces containing named string types",
+		value:     []any{color("red"), "blue"},
+		skipNodes: true,
+		want:      wantValue(`[]any`, `[]any{color("red"), "blue"}`),
+	}
+}
+
+func intKeyedMap() testData {
+	return testData{
+		name:      "Map with int keys",
+		value:     map[int]string{10: "ten", 2: "two", -1: "minus one", 0: "zero"},
+		skipNodes: true,
+		want:      wantValue(`map[int]string`, `map[int]string{-1: "minus one", 0: "zero", 2: "two", 10: "ten"}`),
+	}
+}
+
+func floatKeyedMap() testData {
+	return testData{
+		name:      "Map with float keys",
+		value:     map[float64]bool{2.5: true, -0.5: false, 10: true},
+		skipNodes: true,
+		want:      wantValue(`map[float64]bool`, `map[float64]bool{-0.5: false, 2.5: true, 10.0: true}`),
+	}
+}
+
+type gridPoint struct {
+	X, Y int
+}
+
+type keyOwner struct {
+	Name string
+}
+
+type ratio float64
+
+func floatValues() testData {
+	return testData{
+		name:      "Float values",
+		value:     []any{float32(1.5), 1e-9, ratio(2), math.NaN(), float32(math.Inf(-1))},
+		skipNodes: true,
+		want:      wantValue(`[]any`, `[]any{float32(1.5), 1e-09, ratio(2.0), math.NaN(), float32(math.Inf(-1))}`),
+	}
+}
+
+func structKeyedMap() testData {
+	return testData{
+		name:      "Map with struct keys",
+		value:     map[gridPoint]string{{X: 1, Y: 2}: "a", {X: 0, Y: 1}: "b"},
+		skipNodes: true,
+		want:      wantValue(`map[gridPoint]string`, `map[gridPoint]string{{X: 0, Y: 1}: "b", {X: 1, Y: 2}: "a"}`),
+	}
+}
+
+func arrayKeyedMap() testData {
+	return testData{
+		name:      "Map with array keys",
+		value:     map[[2]int]bool{{3, 4}: true, {1, 2}: false},
+		skipNodes: true,
+		want:      wantValue(`map[[2]int]bool`, `map[[2]int]bool{{1, 2}: false, {3, 4}: true}`),
+	}
+}
+
+func pointerKeyedMap() testData {
+	return testData{
+		name:      "Map with pointer keys",
+		value:     map[*keyOwner]int{{Name: "b"}: 2, {Name: "a"}: 1},
+		skipNodes: true,
+		want: wantValue(`map[*keyOwner]int`,
+			"map[*keyOwner]int{}\n"+
+				"  var2 := keyOwner{Name: \"a\"}\n"+
+				"  var3 := keyOwner{Name: \"b\"}\n"+
+				"  var1[&var2] = 1\n"+
+				"  var1[&var3] = 2",
+		),
+	}
+}
+
+func equalPointerKeyedMap() testData {
+	return testData{
+		name:      "Map with pointer keys to equal values",
+		value:     map[*keyOwner]int{{Name: "a"}: 1, {Name: "a"}: 1},
+		skipNodes: true,
+		want: wantValue(`map[*keyOwner]int`,
+			"map[*keyOwner]int{}\n"+
+				"  var2 := keyOwner{Name: \"a\"}\n"+
+				"  var3 := keyOwner{Name: \"a\"}\n"+
+				"  var1[&var2] = 1\n"+
+				"  var1[&var3] = 1",
+		),
+	}
+}
+
+func interfaceKeyedMap() testData {
+	return testData{
+		name:      "Map with interface keys",
+		value:     map[any]int{gridPoint{X: 1, Y: 2}: 1, "s": 2},
+		skipNodes: true,
+		want:      wantValue(`map[any]int`, `map[any]int{"s": 2, gridPoint{X: 1, Y: 2}: 1}`),
+	}
+}
+
+type registryEntry struct {
+	Name string
+	Peer *registryEntry
+}
+
+func registryMap() testData {
+	a := &registryEntry{Name: "a"}
+	b := &registryEntry{Name: "b", Peer: a}
+	a.Peer = b
+	return testData{
+		name:      "Map of pointers pointing at each other",
+		value:     map[string]*registryEntry{"a": a, "b": b},
+		skipNodes: true,
+		want: wantValue(`map[string]*registryEntry`,
+			"map[string]*registryEntry{\"a\": nil, \"b\": nil}\n"+
+				"  var2 := registryEntry{Name: \"a\", Peer: nil}\n"+
+				"  var3 := registryEntry{Name: \"b\", Peer: nil}\n"+
+				"  var1[\"a\"] = &var2\n"+
+				"  var1[\"b\"] = &var3\n"+
+				"  var2.Peer = &var3\n"+
+				"  var3.Peer = &var2",
+		),
+	}
+}
+
+func selfReferencingMap() testData {
+	m := map[string]any{}
+	m["self"] = m
+	return testData{
+		name:      "Map containing itself",
+		value:     m,
+		skipNodes: true,
+		want: wantValue(`map[string]any`,
+			"map[string]any{\"self\": nil}\n"+
+				"  var1[\"self\"] = var1",
+		),
+	}
+}
+
+type registryWrapper struct {
+	Entry *registryEntry
+}
+
+func mapOfInterfacesWithReferences() testData {
+	e := &registryEntry{Name: "e"}
+	return testData{
+		name:      "Map of interfaces containing references",
+		value:     map[string]any{"n": 1, "p": e, "w": registryWrapper{Entry: e}},
+		skipNodes: true,
+		want: wantValue(`map[string]any`,
+			"map[string]any{\"n\": 1}\n"+
+				"  var2 := registryEntry{Name: \"e\", Peer: nil}\n"+
+				"  var3 := registryWrapper{Entry: nil}\n"+
+				"  var3.Entry = &var2\n"+
+				"  var1[\"p\"] = &var2\n"+
+				"  var1[\"w\"] = var3",
+		),
+	}
+}
+
+func sliceOfStructPointers() testData {
+	return testData{
+		name:      "Slice of pointers to structs",
+		value:     []*registryEntry{{Name: "a"}, {Name: "b"}},
+		skipNodes: true,
+		want: wantValue(`[]*registryEntry`,
+			"[]*registryEntry{nil, nil}\n"+
+				"  var2 := registryEntry{Name: \"a\", Peer: nil}\n"+
+				"  var3 := registryEntry{Name: \"b\", Peer: nil}\n"+
+				"  var1[0] = &var2\n"+
+				"  var1[1] = &var3",
+		),
+	}
+}
+
+type orderHandler struct {
+	prefix string
+}
+
+func (h *orderHandler) Handle(int) error {
+	return nil
+}
+
+type orderHandlerFunc func(int) error
+
+func handleOrder(int) error {
+	return nil
+}
+
+type funcHolder struct {
+	Ref     func(int) error
+	Upper   func(string) string
+	Nil     func(int) error
+	Closure func(int) error
+	Method  func(int) error
+	Named   orderHandlerFunc
+}
+
+func funcFields() testData {
+	count := 0
+	return testData{
+		name: "Struct of func fields",
+		value: &funcHolder{
+			Ref:   handleOrder,
+			Upper: strings.ToUpper,
+			Closure: func(int) error {
+				count++
+				return nil
+			},
+			Method: (&orderHandler{}).Handle,
+			Named:  handleOrder,
+		},
+		skipNodes: true,
+		want: wantPtrValue(`funcHolder`,
+			"funcHolder{Ref: handleOrder, Upper: strings.ToUpper, Nil: nil, Closure: func(int) error {\n"+
+				"    panic(\"typegen: cannot generate closure github.com/mikeschinkel/go-typegen_test.funcFields.func1; add it to CodeBuilder.Funcs\")\n"+
+				"  }, Method: func(int) error {\n"+
+				"    panic(\"typegen: cannot generate method value github.com/mikeschinkel/go-typegen_test.(*orderHandler).Handle-fm; add it to CodeBuilder.Funcs\")\n"+
+				"  }, Named: handleOrder}",
+		),
+	}
+}
+
+func funcsInInterfaces() testData {
+	return testData{
+		name:      "Funcs in interfaces",
+		value:     []any{handleOrder, orderHandlerFunc(handleOrder)},
+		skipNodes: true,
+		want:      wantValue(`[]any`, `[]any{handleOrder, orderHandlerFunc(handleOrder)}`),
+	}
+}
+
+type unsafeHolder struct {
+	Nil  unsafe.Pointer
+	Buf  unsafe.Pointer
+	Addr uintptr
+	Any  any
+}
+
+func unsafeFields() testData {
+	n := int64(5)
+	return testData{
+		name: "Struct of unsafe.Pointer and uintptr fields",
+		value: &unsafeHolder{
+			Buf:  unsafe.Pointer(&n),
+			Addr: uintptr(unsafe.Pointer(&n)),
+			Any:  unsafe.Pointer(&n),
+		},
+		skipNodes: true,
+		want:      wantPtrValue(`unsafeHolder`, `unsafeHolder{Nil: nil, Buf: nil, Addr: uintptr(0), Any: unsafe.Pointer(nil)}`),
+	}
+}
+
+type scalarPointerHolder struct {
+	Count *int64
+	Tags  []string
+}
+
+func pointerToScalarBeforeSlice() testData {
+	n := int64(5)
+	return testData{
+		name:      "Pointer to scalar followed by a slice",
+		value:     &scalarPointerHolder{Count: &n, Tags: []string{"a"}},
+		skipNodes: true,
+		want: wantPtrValue(`scalarPointerHolder`,
+			"scalarPointerHolder{Count: nil, Tags: nil}\n"+
+				"  var2 := int64(5)\n"+
+				"  var3 := []string{\"a\"}\n"+
+				"  var1.Count = &var2\n"+
+				"  var1.Tags = var3",
+		),
+	}
+}
+
+type nilHolder struct {
+	Ptr   *keyItem
+	Map   map[string]int
+	Nil   []int
+	Empty []int
+}
+
+func nilAndEmptyFields() testData {
+	return testData{
+		name:      "Nil and empty fields",
+		value:     &nilHolder{Empty: []int{}},
+		skipNodes: true,
+		want: wantPtrValue(`nilHolder`,
+			"nilHolder{Ptr: nil, Map: nil, Nil: nil, Empty: nil}\n"+
+				"  var2 := []int{}\n"+
+				"  var1.Empty = var2",
+		),
+	}
+}
+
+func typedNilsInInterfaces() testData {
+	return testData{
+		name:      "Typed nils in interfaces",
+		value:     []any{(*keyItem)(nil), []int(nil), map[string]int(nil), (func())(nil), nil},
+		skipNodes: true,
+		want:      wantValue(`[]any`, `[]any{(*keyItem)(nil), []int(nil), map[string]int(nil), (func())(nil), nil}`),
+	}
+}
+
+func nilRootPointer() testData {
+	return testData{
+		name:      "Nil root pointer",
+		value:     (*keyItem)(nil),
+		skipNodes: true,
+		want:      wantValue(`*keyItem`, `(*keyItem)(nil)`),
+	}
+}
+
+type bufHolder struct {
+	Head, Tail []int
+	Line, Word []byte
+}
+
+func sharedBackingArrays() testData {
+	buf := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	line := []byte("hello world")
+	return testData{
+		name:      "Slices sharing backing arrays",
+		value:     &bufHolder{Head: buf[:4], Tail: buf[2:8], Line: line, Word: line[6:]},
+		skipNodes: true,
+		want: wantValueWithReturn(`*bufHolder`,
+			"[10]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}\n"+
+				"  var2 := [11]byte([]byte(\"hello world\"))\n"+
+				"  var3 := bufHolder{Head: nil, Tail: nil, Line: var2[0:11:11], Word: var2[6:11:11]}\n"+
+				"  var4 := var1[0:4:10]\n"+
+				"  var5 := var1[2:8:10]\n"+
+				"  var3.Head = var4\n"+
+				"  var3.Tail = var5",
+			"&var3",
+		),
+	}
+}
+
+func equalSlicesNotShared() testData {
+	return testData{
+		name:      "Equal slices not shared",
+		value:     [][]int{{1, 2}, {1, 2}},
+		skipNodes: true,
+		want: wantValue(`[][]int`,
+			"[][]int{nil, nil}\n"+
+				"  var2 := []int{1, 2}\n"+
+				"  var3 := []int{1, 2}\n"+
+				"  var1[0] = var2\n"+
+				"  var1[1] = var3",
+		),
+	}
+}
+
+type shapeHolder struct {
+	Main   Shape
+	Ptr    Shape
+	List   []Shape
+	ByName map[string]Shape
+}
+
+func interfacesWithDeclaredTypes() testData {
+	sq := &square{Side: 3}
+	return testData{
+		name:      "Interfaces with declared types",
+		value:     &shapeHolder{Main: square{Side: 1}, Ptr: sq, List: []Shape{square{Side: 2}, sq}, ByName: map[string]Shape{"a": square{Side: 4}}},
+		skipNodes: true,
+		want: wantPtrValue(`shapeHolder`,
+			"shapeHolder{Main: square{Side: 1}, Ptr: nil, List: nil, ByName: nil}\n"+
+				"  var2 := square{Side: 3}\n"+
+				"  var3 := []Shape{square{Side: 2}, nil}\n"+
+				"  var4 := map[string]Shape{\"a\": square{Side: 4}}\n"+
+				"  var1.Ptr = &var2\n"+
+				"  var1.List = var3\n"+
+				"  var1.ByName = var4\n"+
+				"  var3[1] = &var2",
+		),
+	}
+}
+
+func containersInInterfaces() testData {
+	return testData{
+		name:      "Containers in interfaces",
+		value:     []any{[]int{1}, map[string]int{"a": 1}},
+		skipNodes: true,
+		want: wantValue(`[]any`,
+			"[]any{nil, nil}\n"+
+				"  var2 := []int{1}\n"+
+				"  var3 := map[string]int{\"a\": 1}\n"+
+				"  var1[0] = var2\n"+
+				"  var1[1] = var3",
+		),
+	}
+}
+
+func pointerToInterface() testData {
+	var sh Shape = square{Side: 7}
+	return testData{
+		name:      "Pointer to interface",
+		value:     &sh,
+		skipNodes: true,
+		want:      wantPtrValue(`Shape`, `Shape(square{Side: 7})`),
+	}
+}
+
+type refHolder struct {
+	P *int
+}
+
+func structWithReferencesInInterface() testData {
+	n := 5
+	return testData{
+		name:      "Struct with references in interface",
+		value:     []any{refHolder{P: &n}},
+		skipNodes: true,
+		want: wantValue(`[]any`,
+			"[]any{nil}\n"+
+				"  var2 := refHolder{P: nil}\n"+
+				"  var3 := 5\n"+
+				"  var2.P = &var3\n"+
+				"  var1[0] = var2",
+		),
+	}
+}
+
+// CodeError is an error of an exported type, which is generated as-is.
+type CodeError struct {
+	Code int
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("code %d", e.Code)
+}
+
+// StatusError is an error of an exported non-pointer type.
+type StatusError int
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("status %d", int(e))
+}
+
+type errorHolder struct {
+	Err  error
+	Errs []error
+}
+
+func errorValues() testData {
+	notFound := errors.New("not found")
+	return testData{
+		name: "Error values",
+		value: &errorHolder{
+			Err: fmt.Errorf("load %q: %w", "app.yaml", os.ErrNotExist),
+			Errs: []error{
+				notFound,
+				fmt.Errorf("read config: %w", io.EOF),
+				fmt.Errorf("100%% done: %w; then %w", notFound, io.ErrUnexpectedEOF),
+				errors.Join(notFound, io.EOF),
+				fmt.Errorf("retry %d: %w", 5, &CodeError{Code: 1}),
+				fmt.Errorf("get: %w", StatusError(404)),
+				nil,
+			},
+		},
+		skipNodes: true,
+		want: wantPtrValue(`errorHolder`,
+			"errorHolder{Err: fmt.Errorf(\"load \\\"app.yaml\\\": %w\", os.ErrNotExist), Errs: nil}\n"+
+				"  var2 := []error{errors.New(\"not found\"), fmt.Errorf(\"read config: %w\", io.EOF), "+
+				"fmt.Errorf(\"100%% done: %w; then %w\", errors.New(\"not found\"), io.ErrUnexpectedEOF), "+
+				"fmt.Errorf(\"%w\\n%w\", errors.New(\"not found\"), io.EOF), "+
+				"fmt.Errorf(\"retry 5: %w\", &CodeError{Code: 1}), fmt.Errorf(\"get: %w\", StatusError(404)), nil}\n"+
+				"  var1.Errs = var2",
+		),
+	}
+}
+
+func durations() testData {
+	return testData{
+		name:      "Durations",
+		value:     []any{1500 * time.Millisecond, time.Hour, 90 * time.Minute, -2 * time.Second, time.Duration(0)},
+		skipNodes: true,
+		want:      wantValue(`[]any`, `[]any{1500 * time.Millisecond, time.Hour, 90 * time.Minute, -2 * time.Second, time.Duration(0)}`),
+	}
+}
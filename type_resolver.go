@@ -0,0 +1,651 @@
+package typegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// TypeResolver resolves the reflect.Type of a Node to the static type declared
+// in the source of the package that defines it, which it loads with
+// `golang.org/x/tools/go/packages`. Unlike the names derived from
+// `reflect.Type.String()` — which is what `rewriteTypename()` and
+// `replaceInterfaceWithAny()` have to work with — the names a TypeResolver
+// produces use the right package name for every package referenced, including
+// those inside the type arguments of generic types, and it records the import
+// paths the generated code needs.
+//
+// Assign a TypeResolver to `CodeBuilder.Types` to have the CodeBuilder use it.
+type TypeResolver struct {
+	// Dir is the directory the go command is run within when loading packages,
+	// which determines the module, and thus the versions of packages, used. It
+	// defaults to the current directory.
+	Dir string
+
+	// BuildFlags are passed to the go command when loading packages, e.g.
+	// `[]string{"-tags", "test"}`.
+	BuildFlags []string
+
+	// omitPath is the import path of the package the generated code will be used
+	// within so that its types will be referenced without a package name.
+	omitPath string
+
+	// packages caches the packages loaded, keyed by import path.
+	packages map[string]*types.Package
+
+	// types caches the types resolved, keyed by reflect.Type.
+	types map[reflect.Type]types.Type
+
+	// constants caches the constants found by `TypeResolver.Constants()`, keyed by
+	// reflect.Type.
+	constants map[reflect.Type][]*types.Const
+
+	// resolving contains the named types being resolved so that recursive generic
+	// types do not recurse infinitely when inferring their type arguments.
+	resolving map[reflect.Type]struct{}
+
+	// imports maps the import path of each package referenced to the name used to
+	// reference it, which differs from the package name if names collide.
+	imports map[string]string
+
+	// names maps each name used to reference a package to its import path.
+	names map[string]string
+}
+
+// NewTypeResolver returns a new *TypeResolver. omitPath is the import path of
+// the package the generated code will be used within, e.g.
+// "github.com/mikeschinkel/go-typegen_test" for the external tests of the
+// `typegen` package, or empty if none.
+func NewTypeResolver(omitPath string) *TypeResolver {
+	return &TypeResolver{
+		omitPath:  omitPath,
+		packages:  make(map[string]*types.Package),
+		types:     make(map[reflect.Type]types.Type),
+		constants: make(map[reflect.Type][]*types.Const),
+		resolving: make(map[reflect.Type]struct{}),
+		imports:   make(map[string]string),
+		names:     make(map[string]string),
+	}
+}
+
+// TypeString returns the name of the type for use in generated code, e.g.
+// `atomic.Pointer[url.URL]` where reflect would return
+// `atomic.Pointer[net/url.URL]`. The packages referenced are recorded so they
+// are included in the list returned by `TypeResolver.Imports()`.
+func (r *TypeResolver) TypeString(rt reflect.Type) (s string, err error) {
+	t, err := r.Type(rt)
+	if err != nil {
+		goto end
+	}
+	s = types.TypeString(t, r.qualifier)
+end:
+	return s, err
+}
+
+// Type returns the static type corresponding to rt.
+func (r *TypeResolver) Type(rt reflect.Type) (t types.Type, err error) {
+	var found bool
+
+	if rt == nil {
+		err = fmt.Errorf("cannot resolve the type of a nil value")
+		goto end
+	}
+	t, found = r.types[rt]
+	if found {
+		goto end
+	}
+	if rt.Name() != "" {
+		t, err = r.namedType(rt)
+	} else {
+		t, err = r.typeLiteral(rt)
+	}
+	if err != nil {
+		goto end
+	}
+	r.types[rt] = t
+end:
+	return t, err
+}
+
+// FieldType returns the declared type of the i'th field of rt, a struct type.
+// For a defined struct type this is the type as written in its declaration, e.g.
+// `rune` where reflect reports `int32`.
+func (r *TypeResolver) FieldType(rt reflect.Type, i int) (t types.Type, err error) {
+	var st *types.Struct
+	var ok bool
+
+	t, err = r.Type(rt)
+	if err != nil {
+		goto end
+	}
+	st, ok = t.Underlying().(*types.Struct)
+	if !ok || i < 0 || i >= st.NumFields() {
+		t = nil
+		err = fmt.Errorf("type '%s' has no field %d", rt, i)
+		goto end
+	}
+	t = st.Field(i).Type()
+end:
+	return t, err
+}
+
+// Constants returns the constants of the defined type rt that are declared in
+// the package that declares rt, in the order they are declared, omitting those
+// that are not exported unless the package is the one at omitPath.
+func (r *TypeResolver) Constants(rt reflect.Type) (consts []*types.Const, err error) {
+	var t types.Type
+	var named *types.Named
+	var scope *types.Scope
+	var found bool
+
+	consts, found = r.constants[rt]
+	if found {
+		goto end
+	}
+	t, err = r.Type(rt)
+	if err != nil {
+		goto end
+	}
+	named, _ = t.(*types.Named)
+	if named == nil || named.Obj().Pkg() == nil {
+		goto cache
+	}
+	scope = named.Obj().Pkg().Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok || !types.Identical(c.Type(), t) {
+			continue
+		}
+		if !c.Exported() && c.Pkg().Path() != r.omitPath {
+			continue
+		}
+		consts = append(consts, c)
+	}
+	sort.Slice(consts, func(i, j int) bool {
+		return consts[i].Pos() < consts[j].Pos()
+	})
+cache:
+	r.constants[rt] = consts
+end:
+	return consts, err
+}
+
+// ObjectString returns the name to reference the package-level object obj by,
+// e.g. `time.March`, recording its package as for `TypeResolver.TypeString()`.
+func (r *TypeResolver) ObjectString(obj types.Object) (s string) {
+	s = obj.Name()
+	if obj.Pkg() == nil {
+		goto end
+	}
+	if name := r.qualifier(obj.Pkg()); name != "" {
+		s = name + "." + s
+	}
+end:
+	return s
+}
+
+// Imports returns the import paths of the packages referenced by the names
+// returned by `TypeResolver.TypeString()`, sorted.
+func (r *TypeResolver) Imports() []string {
+	paths := make([]string, 0, len(r.imports))
+	for path := range r.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ImportSpecs returns an *ast.ImportSpec for each of `TypeResolver.Imports()`,
+// naming the import when the name used differs from the package's name.
+func (r *TypeResolver) ImportSpecs() []*ast.ImportSpec {
+	paths := r.Imports()
+	specs := make([]*ast.ImportSpec, len(paths))
+	for i, path := range paths {
+		specs[i] = &ast.ImportSpec{
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(path)},
+		}
+		pkg := r.packages[path]
+		if pkg != nil && pkg.Name() == r.imports[path] {
+			continue
+		}
+		specs[i].Name = ast.NewIdent(r.imports[path])
+	}
+	return specs
+}
+
+// qualifier is a types.Qualifier that omits the package at omitPath, and records
+// and returns a unique name for every other package.
+func (r *TypeResolver) qualifier(pkg *types.Package) (name string) {
+	var found bool
+	var n int
+
+	if pkg.Path() == r.omitPath {
+		goto end
+	}
+	name, found = r.imports[pkg.Path()]
+	if found {
+		goto end
+	}
+	name = pkg.Name()
+	for {
+		_, found = r.names[name]
+		if !found {
+			break
+		}
+		n++
+		name = fmt.Sprintf("%s%d", pkg.Name(), n+1)
+	}
+	r.imports[pkg.Path()] = name
+	r.names[name] = pkg.Path()
+end:
+	return name
+}
+
+// Package returns the *types.Package for the import path passed, loading it if
+// not already loaded. Import paths ending in `_test` are loaded as the external
+// tests of the package without the suffix.
+func (r *TypeResolver) Package(path string) (pkg *types.Package, err error) {
+	var pkgs []*packages.Package
+	var found bool
+
+	pkg, found = r.packages[path]
+	if found {
+		goto end
+	}
+	// Packages are type-checked from source, including their dependencies, so
+	// loading does not depend on the export data format of the go toolchain.
+	pkgs, err = packages.Load(&packages.Config{
+		Mode: packages.NeedName |
+			packages.NeedTypes |
+			packages.NeedSyntax |
+			packages.NeedImports |
+			packages.NeedDeps,
+		Dir:        r.Dir,
+		BuildFlags: r.BuildFlags,
+		Tests:      strings.HasSuffix(path, "_test"),
+	}, strings.TrimSuffix(path, "_test"))
+	if err != nil {
+		goto end
+	}
+	for _, p := range pkgs {
+		if p.PkgPath != path {
+			continue
+		}
+		if len(p.Errors) > 0 {
+			err = p.Errors[0]
+			goto end
+		}
+		pkg = p.Types
+		break
+	}
+	if pkg == nil {
+		err = fmt.Errorf("package '%s' not found", path)
+		goto end
+	}
+	r.packages[path] = pkg
+end:
+	return pkg, err
+}
+
+// namedType resolves a defined type by looking up its declaration, instantiating
+// it if it is generic.
+func (r *TypeResolver) namedType(rt reflect.Type) (t types.Type, err error) {
+	var pkg *types.Package
+	var obj types.Object
+	var named *types.Named
+	var targs []types.Type
+
+	name := rt.Name()
+	switch rt.PkgPath() {
+	case "":
+		// Predeclared types such as `int` and `error`.
+		obj = types.Universe.Lookup(name)
+		goto found
+	case "unsafe":
+		obj = types.Unsafe.Scope().Lookup(name)
+		goto found
+	}
+	pkg, err = r.Package(rt.PkgPath())
+	if err != nil {
+		goto end
+	}
+	// Generic instantiations are named `List[pkg/path.Item]` by reflect, so look
+	// up the generic type by the name before the type arguments.
+	name, _, _ = strings.Cut(name, "[")
+	obj = pkg.Scope().Lookup(name)
+found:
+	if obj == nil {
+		err = fmt.Errorf("type '%s' not found in package '%s'", name, rt.PkgPath())
+		goto end
+	}
+	t = obj.Type()
+	named, _ = t.(*types.Named)
+	if named == nil || named.TypeParams().Len() == 0 {
+		goto end
+	}
+	targs, err = r.typeArgs(rt, named)
+	if err != nil {
+		goto end
+	}
+	t, err = types.Instantiate(nil, named, targs, false)
+end:
+	return t, err
+}
+
+// typeArgs infers the type arguments of rt, an instantiation of the generic type
+// named, by unifying the underlying type of named with the structure of rt.
+// reflect does not expose type arguments except as part of the type's name, but
+// the types of its fields, elements and so on are those of the instantiation.
+func (r *TypeResolver) typeArgs(rt reflect.Type, named *types.Named) (targs []types.Type, err error) {
+	var tparams *types.TypeParamList
+	var inferred map[*types.TypeParam]types.Type
+
+	if _, found := r.resolving[rt]; found {
+		err = fmt.Errorf("cannot infer the type arguments of recursive type '%s'", rt)
+		goto end
+	}
+	r.resolving[rt] = struct{}{}
+	defer delete(r.resolving, rt)
+
+	inferred = make(map[*types.TypeParam]types.Type)
+	err = r.unify(named.Underlying(), rt, inferred)
+	if err != nil {
+		goto end
+	}
+	tparams = named.TypeParams()
+	targs = make([]types.Type, tparams.Len())
+	for i := 0; i < tparams.Len(); i++ {
+		targ, found := inferred[tparams.At(i)]
+		if !found {
+			err = fmt.Errorf("cannot infer type argument '%s' of '%s'", tparams.At(i), rt)
+			goto end
+		}
+		targs[i] = targ
+	}
+end:
+	return targs, err
+}
+
+// unify walks t, a type that may reference type parameters, in step with rt,
+// recording the type each type parameter corresponds to in inferred.
+func (r *TypeResolver) unify(t types.Type, rt reflect.Type, inferred map[*types.TypeParam]types.Type) (err error) {
+	var resolved types.Type
+
+	switch t := t.(type) {
+	case *types.TypeParam:
+		if _, found := inferred[t]; found {
+			goto end
+		}
+		resolved, err = r.Type(rt)
+		if err != nil {
+			goto end
+		}
+		inferred[t] = resolved
+	case *types.Pointer:
+		if rt.Kind() != reflect.Pointer {
+			goto end
+		}
+		err = r.unify(t.Elem(), rt.Elem(), inferred)
+	case *types.Slice:
+		if rt.Kind() != reflect.Slice {
+			goto end
+		}
+		err = r.unify(t.Elem(), rt.Elem(), inferred)
+	case *types.Array:
+		if rt.Kind() != reflect.Array {
+			goto end
+		}
+		err = r.unify(t.Elem(), rt.Elem(), inferred)
+	case *types.Chan:
+		if rt.Kind() != reflect.Chan {
+			goto end
+		}
+		err = r.unify(t.Elem(), rt.Elem(), inferred)
+	case *types.Map:
+		if rt.Kind() != reflect.Map {
+			goto end
+		}
+		err = r.unify(t.Key(), rt.Key(), inferred)
+		if err != nil {
+			goto end
+		}
+		err = r.unify(t.Elem(), rt.Elem(), inferred)
+	case *types.Struct:
+		if rt.Kind() != reflect.Struct || rt.NumField() != t.NumFields() {
+			goto end
+		}
+		for i := 0; i < t.NumFields(); i++ {
+			err = r.unify(t.Field(i).Type(), rt.Field(i).Type, inferred)
+			if err != nil {
+				goto end
+			}
+		}
+	case *types.Signature:
+		if rt.Kind() != reflect.Func {
+			goto end
+		}
+		err = r.unifyTuple(t.Params(), rt.NumIn(), rt.In, inferred)
+		if err != nil {
+			goto end
+		}
+		err = r.unifyTuple(t.Results(), rt.NumOut(), rt.Out, inferred)
+	case *types.Named:
+		err = r.unifyNamed(t, rt, inferred)
+	}
+end:
+	return err
+}
+
+// unifyTuple unifies the params or results of a func signature.
+func (r *TypeResolver) unifyTuple(tuple *types.Tuple, n int, typ func(int) reflect.Type, inferred map[*types.TypeParam]types.Type) (err error) {
+	if tuple.Len() != n {
+		goto end
+	}
+	for i := 0; i < n; i++ {
+		err = r.unify(tuple.At(i).Type(), typ(i), inferred)
+		if err != nil {
+			goto end
+		}
+	}
+end:
+	return err
+}
+
+// unifyNamed unifies a generic type instantiated with type parameters, e.g. the
+// `Node[T]` of a `next *Node[T]` field, by resolving rt and pairing the type
+// arguments of both.
+func (r *TypeResolver) unifyNamed(t *types.Named, rt reflect.Type, inferred map[*types.TypeParam]types.Type) (err error) {
+	var resolved types.Type
+	var named *types.Named
+	var targs *types.TypeList
+
+	targs = t.TypeArgs()
+	if targs.Len() == 0 {
+		goto end
+	}
+	if _, found := r.resolving[rt]; found {
+		// The type arguments will be inferred from the other fields, if at all.
+		goto end
+	}
+	resolved, err = r.Type(rt)
+	if err != nil {
+		goto end
+	}
+	named, _ = resolved.(*types.Named)
+	if named == nil || named.TypeArgs().Len() != targs.Len() {
+		goto end
+	}
+	for i := 0; i < targs.Len(); i++ {
+		tp, ok := targs.At(i).(*types.TypeParam)
+		if !ok {
+			continue
+		}
+		if _, found := inferred[tp]; found {
+			continue
+		}
+		inferred[tp] = named.TypeArgs().At(i)
+	}
+end:
+	return err
+}
+
+// typeLiteral resolves a type that has no name, e.g. `[]int` or
+// `map[string]*foo.Bar`, by resolving its element types.
+func (r *TypeResolver) typeLiteral(rt reflect.Type) (t types.Type, err error) {
+	var elem, key types.Type
+
+	switch rt.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Chan:
+		elem, err = r.Type(rt.Elem())
+		if err != nil {
+			goto end
+		}
+	case reflect.Map:
+		key, err = r.Type(rt.Key())
+		if err != nil {
+			goto end
+		}
+		elem, err = r.Type(rt.Elem())
+		if err != nil {
+			goto end
+		}
+	}
+	switch rt.Kind() {
+	case reflect.Pointer:
+		t = types.NewPointer(elem)
+	case reflect.Slice:
+		t = types.NewSlice(elem)
+	case reflect.Array:
+		t = types.NewArray(elem, int64(rt.Len()))
+	case reflect.Chan:
+		t = types.NewChan(chanDir(rt.ChanDir()), elem)
+	case reflect.Map:
+		t = types.NewMap(key, elem)
+	case reflect.Func:
+		t, err = r.signature(rt)
+	case reflect.Struct:
+		t, err = r.structType(rt)
+	case reflect.Interface:
+		t, err = r.interfaceType(rt)
+	default:
+		err = fmt.Errorf("cannot resolve unnamed type '%s'", rt)
+	}
+end:
+	return t, err
+}
+
+// signature resolves an unnamed func type.
+func (r *TypeResolver) signature(rt reflect.Type) (t *types.Signature, err error) {
+	var params, results []*types.Var
+
+	params, err = r.tupleVars(rt.NumIn(), rt.In)
+	if err != nil {
+		goto end
+	}
+	results, err = r.tupleVars(rt.NumOut(), rt.Out)
+	if err != nil {
+		goto end
+	}
+	t = types.NewSignatureType(nil, nil, nil,
+		types.NewTuple(params...),
+		types.NewTuple(results...),
+		rt.IsVariadic(),
+	)
+end:
+	return t, err
+}
+
+// tupleVars resolves the params or results of a func type.
+func (r *TypeResolver) tupleVars(n int, typ func(int) reflect.Type) (vars []*types.Var, err error) {
+	vars = make([]*types.Var, n)
+	for i := 0; i < n; i++ {
+		var t types.Type
+		t, err = r.Type(typ(i))
+		if err != nil {
+			goto end
+		}
+		vars[i] = types.NewParam(token.NoPos, nil, "", t)
+	}
+end:
+	return vars, err
+}
+
+// structType resolves an unnamed struct type, keeping its field tags.
+func (r *TypeResolver) structType(rt reflect.Type) (t *types.Struct, err error) {
+	fields := make([]*types.Var, rt.NumField())
+	tags := make([]string, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		var ft types.Type
+		var pkg *types.Package
+
+		f := rt.Field(i)
+		ft, err = r.Type(f.Type)
+		if err != nil {
+			goto end
+		}
+		if f.PkgPath != "" {
+			// Unexported fields belong to the package that declared them.
+			pkg, err = r.Package(f.PkgPath)
+			if err != nil {
+				goto end
+			}
+		}
+		fields[i] = types.NewField(token.NoPos, pkg, f.Name, ft, f.Anonymous)
+		tags[i] = string(f.Tag)
+	}
+	t = types.NewStruct(fields, tags)
+end:
+	return t, err
+}
+
+// interfaceType resolves an unnamed interface type, e.g. `interface{}` which is
+// rendered as `any`.
+func (r *TypeResolver) interfaceType(rt reflect.Type) (t types.Type, err error) {
+	var methods []*types.Func
+
+	if rt.NumMethod() == 0 {
+		t = types.Universe.Lookup("any").Type()
+		goto end
+	}
+	methods = make([]*types.Func, rt.NumMethod())
+	for i := 0; i < rt.NumMethod(); i++ {
+		var sig *types.Signature
+		var pkg *types.Package
+
+		m := rt.Method(i)
+		sig, err = r.signature(m.Type)
+		if err != nil {
+			goto end
+		}
+		if m.PkgPath != "" {
+			pkg, err = r.Package(m.PkgPath)
+			if err != nil {
+				goto end
+			}
+		}
+		methods[i] = types.NewFunc(token.NoPos, pkg, m.Name, sig)
+	}
+	t = types.NewInterfaceType(methods, nil).Complete()
+end:
+	return t, err
+}
+
+// chanDir converts a reflect.ChanDir to a types.ChanDir.
+func chanDir(dir reflect.ChanDir) (d types.ChanDir) {
+	switch dir {
+	case reflect.SendDir:
+		d = types.SendOnly
+	case reflect.RecvDir:
+		d = types.RecvOnly
+	default:
+		d = types.SendRecv
+	}
+	return d
+}
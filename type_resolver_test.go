@@ -0,0 +1,214 @@
+package typegen_test
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mikeschinkel/go-typegen"
+	"github.com/stretchr/testify/assert"
+)
+
+type pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func TestTypeResolver_TypeString(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       any
+		want        string
+		wantImports []string
+	}{
+		{
+			name:  "Predeclared type",
+			value: 10,
+			want:  "int",
+		},
+		{
+			name:        "Map of slices from another package",
+			value:       map[string][]time.Duration{},
+			want:        "map[string][]time.Duration",
+			wantImports: []string{"time"},
+		},
+		{
+			name:        "Generic type argument from nested package",
+			value:       atomic.Pointer[url.URL]{},
+			want:        "atomic.Pointer[url.URL]",
+			wantImports: []string{"net/url", "sync/atomic"},
+		},
+		{
+			name:  "Generic type from package omitted",
+			value: pair[string, any]{},
+			want:  "pair[string, any]",
+		},
+		{
+			name:  "Anonymous struct",
+			value: struct{ ID int }{},
+			want:  "struct{ID int}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := typegen.NewTypeResolver("github.com/mikeschinkel/go-typegen_test")
+			r.BuildFlags = []string{"-tags", "test"}
+			got, err := r.TypeString(reflect.TypeOf(tt.value))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, len(tt.wantImports), len(r.Imports()))
+			for i, path := range tt.wantImports {
+				assert.Equal(t, path, r.Imports()[i])
+			}
+		})
+	}
+}
+
+func TestCodeBuilder_Types(t *testing.T) {
+	m := typegen.NewNodeMarshaler(nil)
+	b := typegen.NewCodeBuilder("getPair", "typegen_test", m.Marshal(&pair[string, int]{Key: "a", Value: 1}))
+	b.Types = typegen.NewTypeResolver("github.com/mikeschinkel/go-typegen_test")
+	b.Types.BuildFlags = []string{"-tags", "test"}
+	assert.Equal(t, "func getPair() *pair[string, int] {\n  var1 := pair[string, int]{Key: \"a\", Value: 1}\n  return &var1\n}", b.String())
+}
+
+type glyph struct {
+	Char  rune
+	Text  []rune
+	Width int32
+}
+
+func TestCodeBuilder_TypesRunes(t *testing.T) {
+	m := typegen.NewNodeMarshaler(nil)
+	b := typegen.NewCodeBuilder("getGlyph", "typegen_test", m.Marshal(&glyph{Char: 'é', Text: []rune("abc"), Width: 2}))
+	b.Types = typegen.NewTypeResolver("github.com/mikeschinkel/go-typegen_test")
+	b.Types.BuildFlags = []string{"-tags", "test"}
+	assert.Equal(t, "func getGlyph() *glyph {\n  var1 := glyph{Char: 'é', Text: nil, Width: 2}\n  var2 := []rune(\"abc\")\n  var1.Text = var2\n  return &var1\n}", b.String())
+}
+
+type hue int
+
+const (
+	red hue = iota
+	green
+	blue
+)
+
+func (c hue) String() (s string) {
+	switch c {
+	case red:
+		s = "red"
+	case green:
+		s = "green"
+	case blue:
+		s = "blue"
+	default:
+		s = fmt.Sprintf("hue(%d)", int(c))
+	}
+	return s
+}
+
+type style uint8
+
+const (
+	bold style = 1 << iota
+	italic
+	underline
+)
+
+func (s style) String() string {
+	var names []string
+	for i, name := range []string{"bold", "italic", "underline"} {
+		if s&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+type tone string
+
+const soft tone = "s"
+
+func (t tone) String() (s string) {
+	s = string(t)
+	if t == soft {
+		s = "soft"
+	}
+	return s
+}
+
+type swatch struct {
+	Hue   hue
+	Style style
+	Month time.Month
+	Tones []tone
+	Hues  []any
+}
+
+func TestCodeBuilder_Constants(t *testing.T) {
+	value := &swatch{Hue: green, Style: bold | underline, Month: time.March, Tones: []tone{soft, "alice"}, Hues: []any{blue, hue(7)}}
+	tests := []struct {
+		name     string
+		stringer bool
+		internal bool
+		types    bool
+		want     string
+	}{
+		{
+			name: "Without constants",
+			want: "swatch{Hue: 1, Style: 5, Month: 3, Tones: nil, Hues: nil}\n" +
+				"  var2 := []tone{\"s\", \"alice\"}\n" +
+				"  var3 := []any{hue(2), hue(7)}",
+		},
+		{
+			name:     "Constants from String()",
+			stringer: true,
+			internal: true,
+			want: "swatch{Hue: green, Style: bold | underline, Month: time.March, Tones: nil, Hues: nil}\n" +
+				"  var2 := []tone{soft, \"alice\"}\n" +
+				"  var3 := []any{blue, hue(7)}",
+		},
+		{
+			name:     "Unexported constants from String()",
+			stringer: true,
+			want: "swatch{Hue: 1, Style: 5, Month: time.March, Tones: nil, Hues: nil}\n" +
+				"  var2 := []tone{\"s\", \"alice\"}\n" +
+				"  var3 := []any{hue(2), hue(7)}",
+		},
+		{
+			name:  "Constants from declarations",
+			types: true,
+			want: "swatch{Hue: green, Style: bold | underline, Month: time.March, Tones: nil, Hues: nil}\n" +
+				"  var2 := []tone{soft, \"alice\"}\n" +
+				"  var3 := []any{blue, hue(7)}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			m.UseStringer = tt.stringer
+			if tt.stringer {
+				// Constants that are not exported are only used within their package.
+				m.Types = typegen.NewTypeResolver("")
+				if tt.internal {
+					m.Types = typegen.NewTypeResolver("github.com/mikeschinkel/go-typegen_test")
+				}
+				m.Types.BuildFlags = []string{"-tags", "test"}
+			}
+			b := typegen.NewCodeBuilder("getSwatch", "typegen_test", m.Marshal(value))
+			if tt.types {
+				b.Types = typegen.NewTypeResolver("github.com/mikeschinkel/go-typegen_test")
+				b.Types.BuildFlags = []string{"-tags", "test"}
+			}
+			want := "func getSwatch() *swatch {\n  var1 := " + tt.want + "\n  var1.Tones = var2\n  var1.Hues = var3\n  return &var1\n}"
+			assert.Equal(t, want, b.String())
+		})
+	}
+}
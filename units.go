@@ -0,0 +1,129 @@
+package typegen
+
+import (
+	"go/ast"
+	"go/token"
+	"math"
+	"reflect"
+	"strconv"
+
+	. "github.com/mikeschinkel/go-lib"
+)
+
+// UnitFormatter generates the values of a unit type, e.g. `time.Duration`, as a
+// count of one of its units so that they are readable, e.g. `1500 *
+// time.Millisecond` rather than `time.Duration(1500000000)`.
+type UnitFormatter interface {
+	// FormatUnit returns the unit to express value in, as the name of a constant
+	// qualified by import path, e.g. `time.Millisecond`, and the count of that unit
+	// that value equals, or false to generate value as usual.
+	FormatUnit(value int64) (count int64, unit string, ok bool)
+}
+
+// Unit is a unit of a unit type, e.g. `time.Millisecond`, named by import path
+// as for UnitFormatter, and its size in the type's smallest unit.
+type Unit struct {
+	Name string
+	Size int64
+}
+
+// Units is a UnitFormatter that expresses a value in the largest of its Units
+// that divides the value exactly, e.g. `90 * time.Minute` for 90 minutes.
+type Units []Unit
+
+// FormatUnit implements UnitFormatter.
+func (us Units) FormatUnit(value int64) (count int64, unit string, ok bool) {
+	var size int64
+
+	if value == 0 {
+		goto end
+	}
+	for _, u := range us {
+		if u.Size <= size || value%u.Size != 0 {
+			continue
+		}
+		size = u.Size
+		unit = u.Name
+	}
+	if size == 0 {
+		goto end
+	}
+	count = value / size
+	ok = true
+end:
+	return count, unit, ok
+}
+
+// DurationUnits formats `time.Duration` values.
+var DurationUnits = Units{
+	{Name: "time.Nanosecond", Size: 1},
+	{Name: "time.Microsecond", Size: 1e3},
+	{Name: "time.Millisecond", Size: 1e6},
+	{Name: "time.Second", Size: 1e9},
+	{Name: "time.Minute", Size: 60e9},
+	{Name: "time.Hour", Size: 3600e9},
+}
+
+// StdFormatters maps the names of the unit types of the standard library, as
+// reflect names them, to the UnitFormatter for their values. It is consulted
+// after `CodeBuilder.Formatters`.
+var StdFormatters = map[string]UnitFormatter{
+	"time.Duration": DurationUnits,
+}
+
+// integerNodeTypes are the NodeTypes of integer values.
+var integerNodeTypes = []NodeType{
+	IntNode,
+	Int8Node,
+	Int16Node,
+	Int32Node,
+	Int64Node,
+	UintNode,
+	Uint8Node,
+	Uint16Node,
+	Uint32Node,
+	Uint64Node,
+}
+
+// unitExpr generates the value of an integer Node of a unit type as a count of
+// one of its units, e.g. `1500 * time.Millisecond`, or just the unit if the
+// count is one, or returns nil if there is no UnitFormatter for the type.
+func (b *CodeBuilder) unitExpr(n *Node) (expr ast.Expr) {
+	var rv reflect.Value
+	var value, count int64
+	var unit, pkg, ident string
+	var f UnitFormatter
+	var found, ok bool
+
+	if n.Nil || !OneOf(n.Type, integerNodeTypes...) {
+		goto end
+	}
+	f, found = b.Formatters[n.Typename]
+	if !found {
+		f, found = StdFormatters[n.Typename]
+	}
+	if !found {
+		goto end
+	}
+	rv = reflect.ValueOf(n.Value)
+	switch {
+	case rv.CanInt():
+		value = rv.Int()
+	case rv.Uint() > math.MaxInt64:
+		goto end
+	default:
+		value = int64(rv.Uint())
+	}
+	count, unit, ok = f.FormatUnit(value)
+	if !ok {
+		goto end
+	}
+	pkg, ident = splitFuncName(unit)
+	expr = b.packageRef(pkg, ident)
+	if count == 1 {
+		goto end
+	}
+	expr = &ast.BinaryExpr{X: numberLit(token.INT, strconv.FormatInt(count, 10)), Op: token.MUL, Y: expr}
+end:
+	return expr
+}
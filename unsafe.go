@@ -0,0 +1,138 @@
+package typegen
+
+import (
+	"go/ast"
+	"reflect"
+)
+
+// UnsafePolicy determines how `unsafe.Pointer` and `uintptr` values are
+// generated. They hold addresses that differ from run to run and that are
+// meaningless in generated code, so their values are never generated as-is.
+type UnsafePolicy int
+
+const (
+	// ZeroUnsafe generates `unsafe.Pointer` values as `nil`, or as
+	// `unsafe.Pointer(nil)` where there is no declared type for `nil` to take on,
+	// and `uintptr` values as `uintptr(0)`.
+	ZeroUnsafe UnsafePolicy = iota
+
+	// PlaceholderUnsafe generates `unsafe.Pointer` and `uintptr` values as calls
+	// to Placeholder() with the path of the value, e.g.
+	// `typegen.Placeholder[unsafe.Pointer](".Buf")`, so they can be found and
+	// replaced in the generated code.
+	PlaceholderUnsafe
+)
+
+// importPath is the import path of this package, which generated code imports
+// to call Placeholder().
+const importPath = "github.com/mikeschinkel/go-typegen"
+
+// Placeholder is called by generated code in place of a value that could not be
+// generated, such as an `unsafe.Pointer`; see PlaceholderUnsafe. It returns the
+// zero value of T. path is the path of the value, e.g. `.Buf`, which marks
+// what needs to be replaced.
+//
+//goland:noinspection GoUnusedParameter
+func Placeholder[T any](path string) (t T) {
+	return t
+}
+
+// unsafePointerExpr returns the conversion of x to `unsafe.Pointer`, e.g.
+// `unsafe.Pointer(&var2)`, and records the import it needs.
+func (b *CodeBuilder) unsafePointerExpr(x ast.Expr) ast.Expr {
+	b.requireImport("unsafe")
+	return callExpr(typeExpr("unsafe.Pointer"), x)
+}
+
+// unsafeTypeExpr returns the type named typ, recording the import of `unsafe`
+// if it is `unsafe.Pointer`. A nil `unsafe.Pointer` generated as a bare `nil`
+// does not need the import, so it is only recorded where the type is named.
+func (b *CodeBuilder) unsafeTypeExpr(typ string) ast.Expr {
+	if typ == "unsafe.Pointer" {
+		b.requireImport("unsafe")
+	}
+	return typeExpr(typ)
+}
+
+// placeholderExpr returns a call to Placeholder() for the Node n of the type
+// named typ, e.g. `typegen.Placeholder[unsafe.Pointer](".Buf")`.
+func (b *CodeBuilder) placeholderExpr(n *Node, typ string) ast.Expr {
+	return callExpr(
+		&ast.IndexExpr{X: b.packageRef(importPath, "Placeholder"), Index: b.unsafeTypeExpr(typ)},
+		stringLit(n.Path()),
+	)
+}
+
+// UnsafePointerNode generates the `unsafe.Pointer` code from a Node. When the
+// NodeMarshaler followed the pointer, see `NodeMarshaler.UnsafePointerTypes`,
+// it is generated as a conversion of the pointer to its target, otherwise as
+// determined by `CodeBuilder.UnsafePolicy`. Nil pointers, including those loaded
+// from a NodeGraph which does not record addresses, are generated as `nil`.
+func (b *CodeBuilder) UnsafePointerNode(n *Node) (expr ast.Expr) {
+	var rv reflect.Value
+
+	typ := b.scalarTypename(n, "unsafe.Pointer")
+	if len(n.nodes) > 0 {
+		expr = b.NodeExpr(n.nodes[0])
+		if isNilIdent(expr) {
+			// The target has yet to be declared and will be assigned, converted, once
+			// it has been; see `CodeBuilder.registerAssignment()`.
+			goto end
+		}
+		expr = b.unsafePointerExpr(expr)
+		goto end
+	}
+	rv = reflect.ValueOf(n.Value)
+	if b.UnsafePolicy == PlaceholderUnsafe && rv.IsValid() && !rv.IsNil() {
+		expr = b.placeholderExpr(n, typ)
+		goto end
+	}
+	expr = ast.NewIdent("nil")
+	if !hasDynamicType(n) {
+		goto end
+	}
+	expr = callExpr(b.unsafeTypeExpr(typ), expr)
+end:
+	return expr
+}
+
+// UintptrNode generates the `uintptr` code from a Node as determined by
+// `CodeBuilder.UnsafePolicy`, except that zero is always generated as zero.
+func (b *CodeBuilder) UintptrNode(n *Node) (expr ast.Expr) {
+	typ := b.scalarTypename(n, "uintptr")
+	if b.UnsafePolicy == PlaceholderUnsafe && reflect.ValueOf(n.Value).Uint() != 0 {
+		expr = b.placeholderExpr(n, typ)
+		goto end
+	}
+	expr = b.conversion(typ, "0")
+end:
+	return expr
+}
+
+// marshalUnsafePointer marshals an `unsafe.Pointer` value. If
+// `NodeMarshaler.UnsafePointerTypes` has a type for the path of the value the
+// pointer is followed, and its target marshaled as the Node's child as if it
+// were a pointer to that type.
+func (m *NodeMarshaler) marshalUnsafePointer(rv *reflect.Value, parent *Node) (node *Node) {
+	var target reflect.Type
+	var elem reflect.Value
+	var found bool
+
+	node = m.NewNode(&NodeArgs{
+		Name:         rv.Type().String(),
+		marshaler:    m,
+		ReflectValue: rv,
+		Parent:       parent,
+	})
+	if rv.IsNil() || parent == nil {
+		goto end
+	}
+	target, found = m.UnsafePointerTypes[node.Path()]
+	if !found {
+		goto end
+	}
+	elem = reflect.NewAt(target, rv.UnsafePointer())
+	node.AddNode(m.marshalValue(&elem, node))
+end:
+	return node
+}
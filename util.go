@@ -1,7 +1,6 @@
 package typegen
 
 import (
-	"fmt"
 	"regexp"
 	"strings"
 )
@@ -21,27 +20,122 @@ func filterMapFunc[M ~map[K]V, K comparable, V any](m M, match func(K, V) bool)
 // `debug` tag is used.
 var resetDebugString = func(any) {}
 
-var iFaceRE = regexp.MustCompile(`^(\W*)interface \{}`)
+var iFaceRE = regexp.MustCompile(`interface \{}`)
 
+// replaceInterfaceWithAny replaces `interface {}` with `any` anywhere in name,
+// e.g. in `map[string]interface {}` or `List[interface {}]`.
 func replaceInterfaceWithAny(name string) string {
-	return iFaceRE.ReplaceAllString(name, "${1}any")
+	return iFaceRE.ReplaceAllString(name, "any")
 }
 
-// maybeStripPackage will remove `foo.` from `foo.Bar`, *foo.Bar`, []foo.Bar` and so on.
-func maybeStripPackage(name, omitPkg string) string {
-	var pkgStripRE *regexp.Regexp
+// rewriteTypename rewrites each package-qualified identifier in a type name
+// returned by `reflect.Type.String()` using the name returned by pkgName for its
+// package, which is either a package name such as `foo` in `foo.Bar`, or an
+// import path such as `github.com/x/foo` in `List[github.com/x/foo.Bar]`. If
+// pkgName returns an empty string the identifier is left unqualified. Quoted
+// strings, e.g. struct tags, are left as-is.
+func rewriteTypename(name string, pkgName func(pkg string) string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(name); {
+		var j int
+		switch c := name[i]; {
+		case c == '"' || c == '`':
+			j = quotedEnd(name, i)
+			sb.WriteString(name[i:j])
+		case isTypenameChar(c):
+			j = i + 1
+			for j < len(name) && isTypenameChar(name[j]) {
+				j++
+			}
+			sb.WriteString(qualifyIdent(name[i:j], pkgName))
+		default:
+			j = i + 1
+			sb.WriteByte(c)
+		}
+		i = j
+	}
+	return sb.String()
+}
 
-	if name == "&" {
+// qualifyIdent rewrites a single, possibly package-qualified identifier such as
+// `github.com/x/foo.Bar` for rewriteTypename(). The leading dots of a variadic
+// parameter, e.g. `...foo.Bar`, are preserved.
+func qualifyIdent(token string, pkgName func(pkg string) string) (s string) {
+	var pkg, ident, prefix string
+	var index int
+
+	s = token
+	ident = strings.TrimLeft(token, ".")
+	prefix = token[:len(token)-len(ident)]
+	index = strings.LastIndexByte(ident, '.')
+	if index <= 0 {
 		goto end
 	}
-	if len(name) == 0 {
+	pkg, ident = ident[:index], ident[index+1:]
+	if !isIdentStart(pkg[0]) || ident == "" || !isIdentStart(ident[0]) {
+		// Not a qualified identifier, e.g. the `1.5` of a float.
 		goto end
 	}
-	if !strings.Contains(name, ".") {
-		goto end
+	pkg = pkgName(pkg)
+	if pkg != "" {
+		ident = pkg + "." + ident
+	}
+	s = prefix + ident
+end:
+	return s
+}
+
+// quotedEnd returns the index just past the end of the quoted string that starts
+// at index start of s, or len(s) if it is not terminated.
+func quotedEnd(s string, start int) (end int) {
+	quote := s[start]
+	for end = start + 1; end < len(s); end++ {
+		if s[end] == '\\' && quote == '"' {
+			end++
+			continue
+		}
+		if s[end] == quote {
+			end++
+			goto end
+		}
 	}
-	pkgStripRE = regexp.MustCompile(fmt.Sprintf(`^(\W*)%s\.`, omitPkg))
-	name = pkgStripRE.ReplaceAllString(name, "$1")
+	end = len(s)
 end:
+	return end
+}
+
+// isTypenameChar returns true for characters that can appear in an identifier
+// qualified by an import path, e.g. `gopkg.in/yaml.v3.Node`.
+func isTypenameChar(c byte) bool {
+	return isIdentStart(c) ||
+		c >= '0' && c <= '9' ||
+		c == '.' || c == '/' || c == '-' || c == '~'
+}
+
+// isIdentStart returns true for the ASCII characters that can start a Go
+// identifier.
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+var majorVersionRE = regexp.MustCompile(`^v[0-9]+$`)
+var dotVersionRE = regexp.MustCompile(`\.v[0-9]+$`)
+
+// guessPackageName returns the name most likely declared by the package at the
+// import path passed, which by convention is its last element ignoring a major
+// version suffix such as `/v2` or `.v3`, and a `go-` prefix or `-go` suffix,
+// e.g. `typegen` for `github.com/mikeschinkel/go-typegen`. A package name such as
+// `foo` is returned as-is. Use `CodeBuilder.ImportAliases` for packages that
+// do not follow the convention.
+func guessPackageName(path string) (name string) {
+	elems := strings.Split(path, "/")
+	name = elems[len(elems)-1]
+	if len(elems) > 1 && majorVersionRE.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	name = dotVersionRE.ReplaceAllString(name, "")
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, "-go")
+	name = strings.NewReplacer("-", "_", ".", "_").Replace(name)
 	return name
 }
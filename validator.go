@@ -0,0 +1,356 @@
+package typegen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// validateFilename is the pseudo filename given to the generated code when it is
+// parsed and type-checked by `CodeBuilder.Validate()`.
+const validateFilename = "typegen_generated.go"
+
+// ValidateArgs configures `CodeBuilder.Validate()`.
+type ValidateArgs struct {
+	// Dir is the directory containing the package the generated code will be used
+	// within. When set, the `.go` files in Dir that `go build` would select and
+	// whose package name matches the CodeBuilder's omitPkg are type-checked along
+	// with the generated code so that types referenced without a package prefix
+	// can be resolved.
+	Dir string
+
+	// Imports lists the import paths the generated code needs, e.g. `reflect` when
+	// Substitutions emit `reflect.ValueOf(...)`.
+	Imports []string
+}
+
+// Diagnostic is a problem found when type-checking generated code, mapped back to
+// the Node whose code caused it, if any.
+type Diagnostic struct {
+	// Node is the innermost Node whose generated code contains the position of the
+	// problem, or nil if the problem is not within code generated for a Node.
+	Node *Node
+
+	// Path is the logical Go path to Node, e.g. `.Orders[2].Customer`.
+	Path string
+
+	// Position is the position of the problem within the generated code.
+	Position token.Position
+
+	// Msg is the message reported by the type checker.
+	Msg string
+}
+
+func (d *Diagnostic) String() string {
+	if d.Node == nil {
+		return fmt.Sprintf("%d:%d: %s", d.Position.Line, d.Position.Column, d.Msg)
+	}
+	return fmt.Sprintf("%d:%d: %s (at %s%s)",
+		d.Position.Line,
+		d.Position.Column,
+		d.Msg,
+		d.Node.Typename,
+		d.Path,
+	)
+}
+
+// Diagnostics is a list of problems found by `CodeBuilder.Validate()`. It
+// implements the error interface.
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	sb := strings.Builder{}
+	sb.WriteString("generated code does not compile:")
+	for _, d := range ds {
+		sb.WriteString("\n\t")
+		sb.WriteString(d.String())
+	}
+	return sb.String()
+}
+
+// Validate parses and type-checks the code returned by `CodeBuilder.Build()` with
+// `go/parser` and `go/types`, and returns Diagnostics as an error if the code
+// does not compile. Each Diagnostic identifies the Node that generated the
+// offending code, so broken output can be reported instead of silently used.
+func (b *CodeBuilder) Validate(args *ValidateArgs) (err error) {
+	var files []*ast.File
+	var file *ast.File
+	var parsed *ast.FuncDecl
+	var diags Diagnostics
+	var pkgName, checkName, src string
+
+	if args == nil {
+		args = &ValidateArgs{}
+	}
+	pkgName = b.omitPkg
+	if pkgName == "" {
+		pkgName = "main"
+	}
+	fset := token.NewFileSet()
+	if args.Dir != "" {
+		files, err = parsePackageFiles(fset, args.Dir, pkgName)
+		if err != nil {
+			goto end
+		}
+	}
+
+	// Rename the generated func so that it does not collide with a func of the same
+	// name that may already exist in the package found in args.Dir.
+	checkName = b.funcName + "_typegen"
+	src = fmt.Sprintf("package %s\n\n%s\n", pkgName, importDecl(b.validateImportSpecs(args.Imports)))
+	src += strings.Replace(b.Build(), "func "+b.funcName, "func "+checkName, 1)
+
+	file, err = parser.ParseFile(fset, validateFilename, src, parser.AllErrors)
+	if err != nil {
+		goto end
+	}
+	parsed = findFuncDecl(file, checkName)
+	files = append(files, file)
+	_, _ = (&types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			te, ok := err.(types.Error)
+			if !ok {
+				return
+			}
+			pos := te.Fset.Position(te.Pos)
+			if pos.Filename != validateFilename {
+				return
+			}
+			diags = append(diags, b.diagnostic(parsed, te, pos))
+		},
+	}).Check(pkgName, fset, files, nil)
+	if len(diags) > 0 {
+		err = diags
+	}
+end:
+	return err
+}
+
+// diagnostic creates a *Diagnostic for a types.Error found in the parsed func,
+// looking up the Node that generated the code found at its position.
+func (b *CodeBuilder) diagnostic(parsed *ast.FuncDecl, te types.Error, pos token.Position) *Diagnostic {
+	d := &Diagnostic{
+		Position: pos,
+		Msg:      te.Msg,
+		Node:     b.nodeAt(parsed, te.Pos),
+	}
+	if d.Node != nil {
+		d.Path = d.Node.Path()
+	}
+	return d
+}
+
+// nodeAt returns the Node that generated the innermost expression containing pos
+// in parsed, which must be the AST parsed from the rendering of the AST built by
+// `CodeBuilder.BuildAST()`. The two ASTs are aligned node by node while
+// descending toward pos, so a subtree whose shape differs between them only
+// loses the Nodes below it. Returns nil if no Node generated code at pos.
+func (b *CodeBuilder) nodeAt(parsed *ast.FuncDecl, pos token.Pos) (n *Node) {
+	var got, want ast.Node
+
+	if parsed == nil {
+		goto end
+	}
+	got, want = parsed, b.FuncDecl()
+	for got != nil {
+		var gotChildren, wantChildren []ast.Node
+
+		if reflect.TypeOf(got) != reflect.TypeOf(want) {
+			break
+		}
+		node, found := b.exprNodes[want]
+		if found {
+			n = node
+		}
+		gotChildren = childNodes(got)
+		wantChildren = childNodes(want)
+		if len(gotChildren) != len(wantChildren) {
+			break
+		}
+		got = nil
+		for i, child := range gotChildren {
+			if pos < child.Pos() || pos >= child.End() {
+				continue
+			}
+			got, want = child, wantChildren[i]
+			break
+		}
+	}
+end:
+	return n
+}
+
+// findFuncDecl returns the *ast.FuncDecl named name from file, or nil if none.
+func findFuncDecl(file *ast.File, name string) (fd *ast.FuncDecl) {
+	for _, decl := range file.Decls {
+		f, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if f.Name.Name != name {
+			continue
+		}
+		fd = f
+		break
+	}
+	return fd
+}
+
+// parsePackageFiles parses, in file name order, the `.go` files in dir that
+// declare package pkgName and match the build constraints of `build.Default`.
+// Files ending in `_test.go` are only parsed when pkgName is an external test
+// package, e.g. `foo_test` for a dir containing package `foo`.
+func parsePackageFiles(fset *token.FileSet, dir, pkgName string) (files []*ast.File, err error) {
+	var pkg *build.Package
+	var names []string
+
+	pkg, err = build.Default.ImportDir(dir, 0)
+	if _, ok := err.(*build.NoGoError); ok {
+		err = nil
+		goto end
+	}
+	if err != nil {
+		goto end
+	}
+	switch pkgName {
+	case pkg.Name:
+		names = append(names, pkg.GoFiles...)
+	case pkg.Name + "_test":
+		names = append(names, pkg.XTestGoFiles...)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var f *ast.File
+		f, err = parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.AllErrors)
+		if err != nil {
+			goto end
+		}
+		files = append(files, f)
+	}
+end:
+	return files, err
+}
+
+// validateImportSpecs returns the import specs of the *ast.File built by
+// `CodeBuilder.BuildAST()`, e.g. those added by `CodeBuilder.Types`, followed
+// by one for each of the import paths passed and those returned by
+// `CodeBuilder.nodeImports()` that are not already included.
+func (b *CodeBuilder) validateImportSpecs(paths []string) (specs []*ast.ImportSpec) {
+	seen := make(map[string]struct{})
+	for _, spec := range b.BuildAST().Imports {
+		specs = append(specs, spec)
+		seen[spec.Path.Value] = struct{}{}
+	}
+	for _, path := range append(paths, b.nodeImports()...) {
+		lit := stringLit(path)
+		if _, found := seen[lit.Value]; found {
+			continue
+		}
+		specs = append(specs, &ast.ImportSpec{Path: lit})
+		seen[lit.Value] = struct{}{}
+	}
+	return specs
+}
+
+// nodeImports returns the import paths of the packages declaring the types of
+// the Nodes, as found by reflect, whose names qualify identifiers in the
+// generated code, e.g. the path of `gen` for `gen.Level(1)`.
+func (b *CodeBuilder) nodeImports() (paths []string) {
+	qualifiers := make(map[string]struct{})
+	ast.Inspect(b.FuncDecl(), func(an ast.Node) bool {
+		sel, ok := an.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok {
+			qualifiers[x.Name] = struct{}{}
+		}
+		return true
+	})
+	pkgs := make(map[string]string)
+	seen := make(map[reflect.Type]struct{})
+	for _, root := range b.nodes[1:] {
+		if root == nil {
+			continue
+		}
+		Walk(root, VisitorFuncs{
+			EnterFunc: func(n *Node, _ string) WalkAction {
+				if rt := n.ReflectType(); rt != nil {
+					addTypePackages(rt, pkgs, seen)
+				}
+				return WalkContinue
+			},
+		})
+	}
+	for path, name := range pkgs {
+		if _, found := qualifiers[name]; !found {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// addTypePackages adds the import path and name of the package declaring rt to
+// pkgs if rt is a named type, or those of the named types rt is composed of if
+// not. The type arguments of generic types are not available from reflect.
+func addTypePackages(rt reflect.Type, pkgs map[string]string, seen map[reflect.Type]struct{}) {
+	if _, found := seen[rt]; found {
+		goto end
+	}
+	seen[rt] = struct{}{}
+	if rt.Name() != "" {
+		if rt.PkgPath() != "" {
+			pkgs[rt.PkgPath()] = rt.String()[:strings.IndexByte(rt.String(), '.')]
+		}
+		goto end
+	}
+	switch rt.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Chan:
+		addTypePackages(rt.Elem(), pkgs, seen)
+	case reflect.Map:
+		addTypePackages(rt.Key(), pkgs, seen)
+		addTypePackages(rt.Elem(), pkgs, seen)
+	case reflect.Struct:
+		for i := 0; i < rt.NumField(); i++ {
+			addTypePackages(rt.Field(i).Type, pkgs, seen)
+		}
+	case reflect.Func:
+		for i := 0; i < rt.NumIn(); i++ {
+			addTypePackages(rt.In(i), pkgs, seen)
+		}
+		for i := 0; i < rt.NumOut(); i++ {
+			addTypePackages(rt.Out(i), pkgs, seen)
+		}
+	}
+end:
+}
+
+// importDecl returns an import declaration for the import specs passed, or an
+// empty string if there are none.
+func importDecl(specs []*ast.ImportSpec) (s string) {
+	if len(specs) == 0 {
+		goto end
+	}
+	s = "import (\n"
+	for _, spec := range specs {
+		s += "\t"
+		if spec.Name != nil {
+			s += spec.Name.Name + " "
+		}
+		s += spec.Path.Value + "\n"
+	}
+	s += ")\n"
+end:
+	return s
+}
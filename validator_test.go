@@ -0,0 +1,104 @@
+package typegen_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mikeschinkel/go-typegen"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeBuilder_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		funcs    typegen.FuncRegistry
+		wantPath string
+	}{
+		{
+			name:  "Valid int slice",
+			value: []int{1, 2, 3},
+		},
+		{
+			name:  "Nil func field",
+			value: &struct{ Handler func(int) error }{},
+		},
+		{
+			name: "Types of other packages",
+			value: &struct {
+				U url.URL
+				M any
+			}{U: url.URL{Host: "example.com"}, M: time.Month(3)},
+		},
+		{
+			name:  "Qualified conversion",
+			value: []any{fs.FileMode(0o644)},
+		},
+		{
+			name:  "Wrapped errors",
+			value: []error{fmt.Errorf("read %d%%: %w", 50, errors.Join(errors.New("short"), io.EOF))},
+		},
+		{
+			name:  "Func field that does not match its signature",
+			value: &struct{ Handler func(int) error }{Handler: handleOrder},
+			funcs: typegen.FuncRegistry{
+				"github.com/mikeschinkel/go-typegen_test.handleOrder": "func(string) {}",
+			},
+			wantPath: ".Handler",
+		},
+		{
+			name: "Func field after a qualified conversion",
+			value: &struct {
+				Mode    any
+				Handler func(int) error
+			}{Mode: fs.FileMode(0o644), Handler: handleOrder},
+			funcs: typegen.FuncRegistry{
+				"github.com/mikeschinkel/go-typegen_test.handleOrder": "func(string) {}",
+			},
+			wantPath: ".Handler",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			b := typegen.NewCodeBuilder("getData", "typegen_test", m.Marshal(tt.value))
+			b.Funcs = tt.funcs
+			err := b.Validate(nil)
+			if tt.wantPath == "" {
+				assert.NoError(t, err)
+				return
+			}
+			diags, ok := err.(typegen.Diagnostics)
+			if !assert.True(t, ok, "expected typegen.Diagnostics, got %v", err) {
+				return
+			}
+			assert.Equal(t, tt.wantPath, diags[0].Path)
+		})
+	}
+}
+
+func TestCodeBuilder_ValidateDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"level.go":          "package fixture\n\ntype level int\n",
+		"ignored.go":        "//go:build ignore\n\npackage fixture\n\nnot Go\n",
+		"level_test.go":     "package fixture\n\nnot Go\n",
+		"level_ext_test.go": "package fixture_test\n\ntype level string\n",
+	}
+	for name, src := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+	m := typegen.NewNodeMarshaler(nil)
+	b := typegen.NewCodeBuilder("getData", "fixture", m.Marshal([]int{1, 2, 3}))
+	assert.NoError(t, b.Validate(&typegen.ValidateArgs{Dir: dir}))
+}
@@ -0,0 +1,123 @@
+package typegen
+
+import (
+	"fmt"
+)
+
+// WalkAction is returned by `Visitor.Enter()` to tell Walk() how to proceed.
+type WalkAction int
+
+const (
+	// WalkContinue continues the walk into the children of the Node.
+	WalkContinue WalkAction = iota
+
+	// WalkSkip skips the children of the Node but continues the walk with the
+	// Node's siblings. `Visitor.Leave()` is still called for the Node.
+	WalkSkip
+
+	// WalkStop ends the walk. `Visitor.Leave()` is not called for the Node nor any
+	// of its ancestors.
+	WalkStop
+)
+
+// Visitor is implemented by types passed to Walk() to visit each Node of a
+// marshaled value.
+type Visitor interface {
+	// Enter is called for each Node before its children are visited, e.g.
+	// pre-order. path is the logical Go path to the Node from the root, e.g.
+	// `.Orders[2].Customer`.
+	Enter(n *Node, path string) WalkAction
+
+	// Leave is called for each Node after its children are visited, e.g.
+	// post-order.
+	Leave(n *Node, path string)
+}
+
+// VisitorFuncs implements Visitor with funcs, either of which may be nil.
+type VisitorFuncs struct {
+	EnterFunc func(n *Node, path string) WalkAction
+	LeaveFunc func(n *Node, path string)
+}
+
+func (v VisitorFuncs) Enter(n *Node, path string) (action WalkAction) {
+	if v.EnterFunc != nil {
+		action = v.EnterFunc(n, path)
+	}
+	return action
+}
+
+func (v VisitorFuncs) Leave(n *Node, path string) {
+	if v.LeaveFunc != nil {
+		v.LeaveFunc(n, path)
+	}
+}
+
+// Walk visits root and every Node reachable from it, calling `Visitor.Enter()`
+// and `Visitor.Leave()` for each. The Field and Element Nodes that
+// `NodeMarshaler` uses to wrap struct fields and slice, array and map elements
+// are not visited themselves; instead they contribute to the path passed to the
+// Visitor, i.e. `.Field`, `[2]` and `["key"]`. Pointers and interfaces are
+// visited, but do not contribute to the path. A Node referenced more than once,
+// such as by a pointer back to a containing value, is only visited the first
+// time it is reached.
+func Walk(root *Node, v Visitor) {
+	w := &walker{
+		visitor: v,
+		seen:    make(map[*Node]struct{}),
+	}
+	w.walk(root, "")
+}
+
+// walker holds the state of a call to Walk().
+type walker struct {
+	visitor Visitor
+	seen    map[*Node]struct{}
+}
+
+// walk visits n and its children, returning true if the walk was stopped.
+func (w *walker) walk(n *Node, path string) (stop bool) {
+	if n == nil {
+		goto end
+	}
+	if _, found := w.seen[n]; found {
+		goto end
+	}
+	w.seen[n] = struct{}{}
+	switch w.visitor.Enter(n, path) {
+	case WalkStop:
+		stop = true
+		goto end
+	case WalkSkip:
+		goto leave
+	}
+	stop = w.walkChildren(n, path)
+	if stop {
+		goto end
+	}
+leave:
+	w.visitor.Leave(n, path)
+end:
+	return stop
+}
+
+// walkChildren visits the children of n, unwrapping Field and Element Nodes and
+// extending path accordingly. Returns true if the walk was stopped.
+func (w *walker) walkChildren(n *Node, path string) (stop bool) {
+	for _, child := range n.nodes {
+		switch {
+		case child.Type == FieldNode && len(child.nodes) > 0:
+			stop = w.walk(child.nodes[0], path+"."+child.Name)
+		case child.Type == ElementNode && len(child.nodes) > 0:
+			stop = w.walk(child.nodes[0], fmt.Sprintf("%s[%d]", path, child.Index))
+		case n.Type == MapNode && len(child.nodes) > 0:
+			// child is the key and its sole child is the value.
+			stop = w.walk(child.nodes[0], fmt.Sprintf("%s[%s]", path, child.keyString()))
+		default:
+			stop = w.walk(child, path)
+		}
+		if stop {
+			break
+		}
+	}
+	return stop
+}
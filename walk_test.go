@@ -0,0 +1,66 @@
+package typegen_test
+
+import (
+	"testing"
+
+	"github.com/mikeschinkel/go-typegen"
+	"github.com/stretchr/testify/assert"
+)
+
+type walkItem struct {
+	Qty int
+}
+
+type walkOrder struct {
+	Customer string
+	Items    []walkItem
+	Tags     map[string]int
+}
+
+func TestWalk(t *testing.T) {
+	order := &walkOrder{
+		Customer: "Ann",
+		Items:    []walkItem{{Qty: 1}, {Qty: 2}},
+		Tags:     map[string]int{"rush": 1},
+	}
+	tests := []struct {
+		name string
+		skip string
+		want []string
+	}{
+		{
+			name: "All scalars",
+			want: []string{`.Customer`, `.Items[0].Qty`, `.Items[1].Qty`, `.Tags["rush"]`},
+		},
+		{
+			name: "Skip items",
+			skip: ".Items",
+			want: []string{`.Customer`, `.Tags["rush"]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := typegen.NewNodeMarshaler(nil)
+			nodes := m.Marshal(order)
+			var got []string
+			var depth int
+			typegen.Walk(nodes[1], typegen.VisitorFuncs{
+				EnterFunc: func(n *Node, path string) typegen.WalkAction {
+					depth++
+					if tt.skip != "" && path == tt.skip {
+						return typegen.WalkSkip
+					}
+					if len(n.Nodes()) == 0 {
+						got = append(got, path)
+					}
+					return typegen.WalkContinue
+				},
+				LeaveFunc: func(n *Node, path string) {
+					depth--
+				},
+			})
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, 0, depth)
+		})
+	}
+}